@@ -1,7 +1,9 @@
 package koda
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"sync"
 	"time"
@@ -9,11 +11,13 @@ import (
 	"gopkg.in/redis.v3"
 )
 
+// pollInterval is how long a subscription waits before receiving again, after
+// failing to receive a message
+const pollInterval = 100 * time.Millisecond
+
 // redisAdapter is an adapter for the redis.v3 library
 type redisAdapter struct {
-	R                 *redis.Client
-	subscriptions     map[string][]chan string
-	subscriptionsLock sync.RWMutex
+	R *redis.Client
 }
 
 func (r *redisAdapter) Incr(key string) (int, error) {
@@ -21,6 +25,11 @@ func (r *redisAdapter) Incr(key string) (int, error) {
 	return int(cmd.Val()), cmd.Err()
 }
 
+func (r *redisAdapter) IncrBy(key string, n int) (int, error) {
+	cmd := r.R.IncrBy(key, int64(n))
+	return int(cmd.Val()), cmd.Err()
+}
+
 func (r *redisAdapter) HGetAll(key string) ([]string, error) {
 	return r.R.HGetAll(key).Result()
 }
@@ -35,12 +44,17 @@ func (r *redisAdapter) RPush(key string, value ...string) (int, error) {
 	return int(cmd.Val()), cmd.Err()
 }
 
-func (r *redisAdapter) BLPop(timeout time.Duration, keys ...string) ([]string, error) {
-	return r.R.BLPop(timeout, keys...).Result()
+func (r *redisAdapter) ZAdd(key string, score float64, member string) (int, error) {
+	cmd := r.R.ZAdd(key, redis.Z{
+		Score:  score,
+		Member: member,
+	})
+
+	return int(cmd.Val()), cmd.Err()
 }
 
-func (r *redisAdapter) ZAddNX(key string, score float64, member string) (int, error) {
-	cmd := r.R.ZAddNX(key, redis.Z{
+func (r *redisAdapter) ZAddXX(key string, score float64, member string) (int, error) {
+	cmd := r.R.ZAddXXCh(key, redis.Z{
 		Score:  score,
 		Member: member,
 	})
@@ -48,43 +62,513 @@ func (r *redisAdapter) ZAddNX(key string, score float64, member string) (int, er
 	return int(cmd.Val()), cmd.Err()
 }
 
-func (r *redisAdapter) ZPopByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) ([]string, error) {
+func (r *redisAdapter) ZRem(key string, members ...string) (int, error) {
+	cmd := r.R.ZRem(key, members...)
+	return int(cmd.Val()), cmd.Err()
+}
+
+func (r *redisAdapter) ZRangeByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) ([]string, error) {
+	rangeStr := formatScoreRange(min, max, minIncl, maxIncl)
+	return r.R.ZRangeByScore(key, redis.ZRangeByScore{
+		Min:    rangeStr[0],
+		Max:    rangeStr[1],
+		Offset: int64(offset),
+		Count:  int64(count),
+	}).Result()
+}
+
+func (r *redisAdapter) ZPopByScoreZAdd(key string, min, max float64, minIncl, maxIncl bool, offset, count int, dest string, score float64) ([]string, error) {
 	script := `
 	local res = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[2], 'LIMIT', ARGV[3], ARGV[4])
 	for i=1,#res do
 		redis.call('ZREM', KEYS[1], res[i])
+		redis.call('ZADD', KEYS[2], ARGV[5], res[i])
 	end
 	return res
 	`
-	var rangeStr [2]string
-	ranges := []float64{min, max}
-	inclusive := []bool{minIncl, maxIncl}
-
-	for i := range ranges {
-		rangeStr[i] = strconv.FormatFloat(ranges[i], 'E', -1, 64)
-		if !inclusive[i] {
-			rangeStr[i] = fmt.Sprintf("(%s", rangeStr[i])
-		}
-	}
+	rangeStr := formatScoreRange(min, max, minIncl, maxIncl)
 
-	cmd := r.R.Eval(script, []string{key}, []string{
+	cmd := r.R.Eval(script, []string{key, dest}, []string{
 		rangeStr[0],
 		rangeStr[1],
 		strconv.Itoa(offset),
 		strconv.Itoa(count),
+		formatScore(score),
 	})
 
+	return stringsResult(cmd)
+}
+
+const claimJobScript = `
+local claimed = {}
+local queued = 0
+for i=3,#KEYS do
+	if #claimed == 0 then
+		local val = redis.call('LPOP', KEYS[i])
+		if val then
+			redis.call('ZADD', KEYS[1], ARGV[1], val)
+			claimed = {KEYS[i], val}
+		end
+	end
+	queued = queued + redis.call('LLEN', KEYS[i])
+end
+
+-- The wake list only needs a value for each job still queued, as values for
+-- claimed or removed jobs would only wake workers needlessly
+if queued == 0 then
+	redis.call('DEL', KEYS[2])
+else
+	redis.call('LTRIM', KEYS[2], -queued, -1)
+end
+
+return claimed
+`
+
+// ClaimJob blocks on wakeKey with BLPOP, as there is no blocking redis command
+// that can atomically move a value between a list and a sorted set.
+func (r *redisAdapter) ClaimJob(timeout time.Duration, wakeKey, dest string, score float64, keys ...string) ([]string, error) {
+	keys = append([]string{dest, wakeKey}, keys...)
+	args := []string{formatScore(score)}
+
+	results, err := stringsResult(r.R.Eval(claimJobScript, keys, args))
+	if err != nil || len(results) > 0 {
+		return results, err
+	}
+
+	// BLPOP's timeout is in whole seconds, and a timeout of 0 blocks forever
+	if timeout < time.Second {
+		return nil, nil
+	}
+
+	err = r.R.BLPop(timeout, wakeKey).Err()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return stringsResult(r.R.Eval(claimJobScript, keys, args))
+}
+
+const submitJobScript = `
+local id = ARGV[1]
+if KEYS[1] ~= '' then
+	id = tostring(redis.call('INCR', KEYS[1]))
+end
+
+local jobKey = ARGV[2] .. id
+redis.call('HMSET', jobKey, unpack(ARGV, 4))
+if KEYS[1] ~= '' then
+	redis.call('HSET', jobKey, 'id', id)
+end
+
+if KEYS[2] ~= '' then
+	redis.call('RPUSH', KEYS[2], jobKey)
+	if KEYS[4] ~= '' then
+		redis.call('RPUSH', KEYS[4], jobKey)
+	end
+end
+if KEYS[3] ~= '' then
+	redis.call('ZADD', KEYS[3], 'NX', ARGV[3], jobKey)
+end
+
+return tonumber(id)
+`
+
+func (r *redisAdapter) SubmitJob(idKey string, id int, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) (int, error) {
+	keys := []string{idKey, listKey, delayedKey, wakeKey}
+	args := []string{strconv.Itoa(id), keyPrefix, formatScore(score)}
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+
+	cmd := r.R.Eval(submitJobScript, keys, args)
 	if cmd.Err() != nil {
-		return nil, cmd.Err()
+		return 0, cmd.Err()
 	}
 
-	var members []string
-	val := cmd.Val().([]interface{})
-	for i := range val {
-		members = append(members, val[i].(string))
+	return int(cmd.Val().(int64)), nil
+}
+
+const submitUniqueJobScript = `
+local existing = redis.call('GET', KEYS[1])
+if existing then
+	return {tonumber(existing), 0}
+end
+
+local id = tostring(redis.call('INCR', KEYS[2]))
+local jobKey = ARGV[1] .. id
+redis.call('HMSET', jobKey, unpack(ARGV, 4))
+redis.call('HSET', jobKey, 'id', id)
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], id, 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], id)
+end
+
+if KEYS[3] ~= '' then
+	redis.call('RPUSH', KEYS[3], jobKey)
+	if KEYS[5] ~= '' then
+		redis.call('RPUSH', KEYS[5], jobKey)
+	end
+end
+if KEYS[4] ~= '' then
+	redis.call('ZADD', KEYS[4], 'NX', ARGV[2], jobKey)
+end
+
+return {tonumber(id), 1}
+`
+
+func (r *redisAdapter) SubmitUniqueJob(uniqueKey string, ttl time.Duration, idKey, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) (int, bool, error) {
+	keys := []string{uniqueKey, idKey, listKey, delayedKey, wakeKey}
+	args := []string{keyPrefix, formatScore(score), formatMillis(ttl)}
+	for k, v := range fields {
+		args = append(args, k, v)
 	}
 
-	return members, nil
+	return idCreatedResult(r.R.Eval(submitUniqueJobScript, keys, args))
+}
+
+const debounceJobScript = `
+local numUpdate = tonumber(ARGV[4])
+local existing = redis.call('GET', KEYS[1])
+if existing then
+	local jobKey = ARGV[1] .. existing
+	if redis.call('ZSCORE', KEYS[3], jobKey) then
+		local update = {}
+		for i=5,4+numUpdate do
+			update[ARGV[i]] = true
+		end
+		for i=5+numUpdate,#ARGV,2 do
+			if update[ARGV[i]] then
+				redis.call('HSET', jobKey, ARGV[i], ARGV[i+1])
+			end
+		end
+
+		redis.call('ZADD', KEYS[3], ARGV[2], jobKey)
+		redis.call('PEXPIRE', KEYS[1], ARGV[3])
+		return {tonumber(existing), 0}
+	end
+end
+
+local id = tostring(redis.call('INCR', KEYS[2]))
+local jobKey = ARGV[1] .. id
+redis.call('HMSET', jobKey, unpack(ARGV, 5+numUpdate))
+redis.call('HSET', jobKey, 'id', id)
+redis.call('ZADD', KEYS[3], ARGV[2], jobKey)
+redis.call('SET', KEYS[1], id, 'PX', ARGV[3])
+
+return {tonumber(id), 1}
+`
+
+func (r *redisAdapter) DebounceJob(debounceKey string, ttl time.Duration, idKey, keyPrefix string, fields map[string]string, updateFields []string, delayedKey string, score float64) (int, bool, error) {
+	keys := []string{debounceKey, idKey, delayedKey}
+	args := []string{keyPrefix, formatScore(score), formatMillis(ttl), strconv.Itoa(len(updateFields))}
+	args = append(args, updateFields...)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+
+	return idCreatedResult(r.R.Eval(debounceJobScript, keys, args))
+}
+
+// idCreatedResult parses the result of a script that returns a job's id, and
+// whether the job was created
+func idCreatedResult(cmd *redis.Cmd) (int, bool, error) {
+	res, err := cmd.Result()
+	if err != nil {
+		return 0, false, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, false, fmt.Errorf("unexpected result: %v", res)
+	}
+
+	id, _ := vals[0].(int64)
+	created, _ := vals[1].(int64)
+	return int(id), created == 1, nil
+}
+
+const moveJobScript = `
+local numStates = tonumber(ARGV[3])
+local state = redis.call('HGET', KEYS[1], 'state')
+local allowed = false
+for i=4,3+numStates do
+	if ARGV[i] == state then
+		allowed = true
+	end
+end
+if not allowed then
+	return 0
+end
+
+local removed = 0
+if KEYS[2] ~= '' then
+	removed = removed + redis.call('LREM', KEYS[2], 0, KEYS[1])
+end
+if KEYS[3] ~= '' then
+	removed = removed + redis.call('ZREM', KEYS[3], KEYS[1])
+end
+
+if ARGV[1] == '1' and removed == 0 then
+	return 0
+end
+
+if #ARGV > 3+numStates then
+	redis.call('HMSET', KEYS[1], unpack(ARGV, 4+numStates))
+end
+
+if KEYS[4] ~= '' then
+	redis.call('RPUSH', KEYS[4], KEYS[1])
+	if KEYS[6] ~= '' then
+		redis.call('RPUSH', KEYS[6], KEYS[1])
+	end
+end
+if KEYS[5] ~= '' then
+	redis.call('ZADD', KEYS[5], ARGV[2], KEYS[1])
+end
+
+return 1
+`
+
+func (r *redisAdapter) MoveJob(jobKey string, states []string, fromList, fromZSet, toList, wakeKey, toZSet string, score float64, fields map[string]string, onlyIfRemoved bool) (bool, error) {
+	keys := []string{jobKey, fromList, fromZSet, toList, toZSet, wakeKey}
+	args := []string{"0", formatScore(score), strconv.Itoa(len(states))}
+	if onlyIfRemoved {
+		args[0] = "1"
+	}
+	args = append(args, states...)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+
+	cmd := r.R.Eval(moveJobScript, keys, args)
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+
+	return cmd.Val().(int64) == 1, nil
+}
+
+// completeJobScript atomically places a job that has finished, died or been
+// canceled in its terminal state, queues its follow-up jobs, and counts it
+// towards its batch. KEYS[1] is the job's key, and ARGV[1] is a
+// completeJobArgs, encoded as JSON.
+const completeJobScript = `
+local c = cjson.decode(ARGV[1])
+local key = KEYS[1]
+
+if c.states then
+	local state = redis.call('HGET', key, 'state')
+	local found = false
+	for _, s in ipairs(c.states) do
+		if s == state then
+			found = true
+		end
+	end
+	if not found then
+		return 0
+	end
+end
+
+if c.from_list or c.from_zset then
+	local removed = 0
+	if c.from_list then
+		removed = removed + redis.call('LREM', c.from_list, 0, key)
+	end
+	if c.from_zset then
+		removed = removed + redis.call('ZREM', c.from_zset, key)
+	end
+	if removed == 0 then
+		return 0
+	end
+end
+
+local function hset(k, fields)
+	local args = {}
+	for f, v in pairs(fields) do
+		table.insert(args, f)
+		table.insert(args, v)
+	end
+	if #args > 0 then
+		redis.call('HMSET', k, unpack(args))
+	end
+end
+
+hset(key, c.fields)
+for _, job in ipairs(c.jobs or {}) do
+	hset(job.key, job.fields)
+	redis.call('RPUSH', job.list_key, job.key)
+	redis.call('RPUSH', job.wake_key, job.key)
+end
+
+if not c.batch_key then
+	return 1
+end
+
+redis.call('HINCRBY', c.batch_key, c.batch_counter, 1)
+if redis.call('HINCRBY', c.batch_key, 'pending', -1) ~= 0 then
+	return 1
+end
+
+local batch = redis.call('HGETALL', c.batch_key)
+local summary = {}
+local callbackKey, callbackList, callbackWake
+for i=1,#batch,2 do
+	if batch[i] == 'callback_key' then
+		callbackKey = batch[i+1]
+	elseif batch[i] == 'callback_list' then
+		callbackList = batch[i+1]
+	elseif batch[i] == 'callback_wake' then
+		callbackWake = batch[i+1]
+	elseif tonumber(batch[i+1]) then
+		summary[batch[i]] = tonumber(batch[i+1])
+	end
+end
+
+redis.call('HMSET', callbackKey, 'payload', cjson.encode(summary), 'state', c.callback_state)
+redis.call('RPUSH', callbackList, callbackKey)
+if callbackWake then
+	redis.call('RPUSH', callbackWake, callbackKey)
+end
+return 1
+`
+
+// completeJobArgs is the argument of completeJobScript.
+type completeJobArgs struct {
+	States        []string           `json:"states,omitempty"`
+	FromList      string             `json:"from_list,omitempty"`
+	FromZSet      string             `json:"from_zset,omitempty"`
+	Fields        map[string]string  `json:"fields"`
+	Jobs          []completeJobsArgs `json:"jobs,omitempty"`
+	BatchKey      string             `json:"batch_key,omitempty"`
+	BatchCounter  string             `json:"batch_counter,omitempty"`
+	CallbackState string             `json:"callback_state,omitempty"`
+}
+
+type completeJobsArgs struct {
+	Key     string            `json:"key"`
+	Fields  map[string]string `json:"fields"`
+	ListKey string            `json:"list_key"`
+	WakeKey string            `json:"wake_key"`
+}
+
+func (r *redisAdapter) CompleteJob(jobKey string, states []string, fromList, fromZSet string, fields map[string]string, jobs []FollowUpJob, batchKey, counter, callbackState string) (bool, error) {
+	args := completeJobArgs{
+		States:   states,
+		FromList: fromList,
+		FromZSet: fromZSet,
+		Fields:   fields,
+	}
+	if args.Fields == nil {
+		args.Fields = make(map[string]string)
+	}
+	for _, j := range jobs {
+		args.Jobs = append(args.Jobs, completeJobsArgs(j))
+	}
+	if batchKey != "" {
+		args.BatchKey = batchKey
+		args.BatchCounter = counter
+		args.CallbackState = callbackState
+	}
+
+	arg, err := json.Marshal(args)
+	if err != nil {
+		return false, err
+	}
+
+	cmd := r.R.Eval(completeJobScript, []string{jobKey}, []string{string(arg)})
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+
+	return cmd.Val().(int64) == 1, nil
+}
+
+const promoteJobsScript = `
+local jobKeys = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, jobKey in ipairs(jobKeys) do
+	local priority = redis.call('HGET', jobKey, 'priority') or '0'
+	redis.call('RPUSH', ARGV[2] .. priority, jobKey)
+	redis.call('RPUSH', KEYS[2], jobKey)
+	redis.call('ZREM', KEYS[1], jobKey)
+end
+
+local next = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if #next == 0 then
+	return ''
+end
+
+return next[2]
+`
+
+func (r *redisAdapter) PromoteJobs(key string, max float64, listKeyPrefix, wakeKey string, count int) (float64, error) {
+	args := []string{formatScore(max), listKeyPrefix, strconv.Itoa(count)}
+	res, err := r.R.Eval(promoteJobsScript, []string{key, wakeKey}, args).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	next, ok := res.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected result: %v", res)
+	}
+
+	if next == "" {
+		return math.Inf(1), nil
+	}
+
+	return strconv.ParseFloat(next, 64)
+}
+
+func (r *redisAdapter) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	return r.R.SetNX(key, value, ttl).Result()
+}
+
+const acquireLockScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+
+return 0
+`
+
+func (r *redisAdapter) AcquireLock(key, value string, ttl time.Duration) (bool, error) {
+	cmd := r.R.Eval(acquireLockScript, []string{key}, []string{value, formatMillis(ttl)})
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+
+	return cmd.Val().(int64) == 1, nil
+}
+
+const expireIfEqualScript = `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+else
+	redis.call('DEL', KEYS[1])
+end
+
+return 1
+`
+
+func (r *redisAdapter) ExpireIfEqual(key, value string, ttl time.Duration) (bool, error) {
+	cmd := r.R.Eval(expireIfEqualScript, []string{key}, []string{value, formatMillis(ttl)})
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+
+	return cmd.Val().(int64) == 1, nil
 }
 
 func (r *redisAdapter) Scan(cursor int, match string, count int) (int, []string, error) {
@@ -93,32 +577,209 @@ func (r *redisAdapter) Scan(cursor int, match string, count int) (int, []string,
 	return int(offset), results, cmd.Err()
 }
 
-func (r *redisAdapter) Subscribe(channel string) (<-chan string, error) {
-	r.subscriptionsLock.Lock()
-	defer r.subscriptionsLock.Unlock()
-	if r.subscriptions == nil {
-		r.subscriptions = make(map[string][]chan string)
+func (r *redisAdapter) Publish(channel string, message string) (int, error) {
+	cmd := r.R.Publish(channel, message)
+	return int(cmd.Val()), cmd.Err()
+}
+
+func (r *redisAdapter) Pipeline() Pipeline {
+	return &redisPipeline{R: r.R}
+}
+
+// redisPipeline queues commands until Exec, which sends them in a single
+// MULTI/EXEC transaction.
+type redisPipeline struct {
+	R    *redis.Client
+	cmds []func(m *redis.Multi)
+}
+
+func (p *redisPipeline) HSetAll(key string, fields map[string]string) {
+	p.cmds = append(p.cmds, func(m *redis.Multi) {
+		m.HMSetMap(key, fields)
+	})
+}
+
+func (p *redisPipeline) RPush(key string, value ...string) {
+	p.cmds = append(p.cmds, func(m *redis.Multi) {
+		m.RPush(key, value...)
+	})
+}
+
+func (p *redisPipeline) ZAdd(key string, score float64, member string) {
+	p.cmds = append(p.cmds, func(m *redis.Multi) {
+		m.ZAdd(key, redis.Z{Score: score, Member: member})
+	})
+}
+
+func (p *redisPipeline) Publish(channel string, message string) {
+	p.cmds = append(p.cmds, func(m *redis.Multi) {
+		m.Publish(channel, message)
+	})
+}
+
+func (p *redisPipeline) Exec() error {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return nil
 	}
-	ch := make(chan string)
-	r.subscriptions[channel] = append(r.subscriptions[channel], ch)
 
+	multi := p.R.Multi()
+	defer multi.Close()
+
+	_, err := multi.Exec(func() error {
+		for _, cmd := range cmds {
+			cmd(multi)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (r *redisAdapter) Subscribe(channel string) (<-chan string, func() error, error) {
 	ps, err := r.R.Subscribe(channel)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	ch, cancel := subscribe(ps)
+	return ch, cancel, nil
+}
+
+func (r *redisAdapter) PSubscribe(pattern string) (<-chan []string, func() error, error) {
+	ps, err := r.R.PSubscribe(pattern)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	ch, cancel := psubscribe(ps)
+	return ch, cancel, nil
+}
+
+// pubSub is the part of *redis.PubSub used by subscriptions.
+type pubSub interface {
+	ReceiveMessage() (*redis.Message, error)
+	Close() error
+}
+
+// subscribe delivers the payload of each message received from ps on the
+// returned channel, until the returned function is called, which closes ps
+// and the channel.
+func subscribe(ps pubSub) (<-chan string, func() error) {
+	s := &subscription{ps: ps, done: make(chan struct{})}
+	ch := make(chan string)
 	go func() {
-		msg, _ := ps.ReceiveMessage()
-		r.subscriptionsLock.Lock()
-		for i := range r.subscriptions[channel] {
-			r.subscriptions[channel][i] <- msg.Payload
-		}
-		r.subscriptionsLock.Unlock()
+		defer close(ch)
+		s.receive(func(msg *redis.Message) bool {
+			select {
+			case ch <- msg.Payload:
+				return true
+			case <-s.done:
+				return false
+			}
+		})
+	}()
+
+	return ch, s.cancel
+}
+
+// psubscribe has the same interface as subscribe, but delivers each message
+// as the channel it was published to, followed by its payload.
+func psubscribe(ps pubSub) (<-chan []string, func() error) {
+	s := &subscription{ps: ps, done: make(chan struct{})}
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		s.receive(func(msg *redis.Message) bool {
+			select {
+			case ch <- []string{msg.Channel, msg.Payload}:
+				return true
+			case <-s.done:
+				return false
+			}
+		})
 	}()
 
-	return ch, nil
+	return ch, s.cancel
+}
+
+// subscription receives messages from a redis pub/sub connection until it
+// is cancelled
+type subscription struct {
+	ps   pubSub
+	done chan struct{}
+	once sync.Once
+}
+
+// receive passes each received message to send, until the subscription is
+// cancelled or send returns false. Receive errors are retried, as the
+// connection is reestablished by the redis library.
+func (s *subscription) receive(send func(msg *redis.Message) bool) {
+	for {
+		msg, err := s.ps.ReceiveMessage()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			case <-time.After(pollInterval):
+				continue
+			}
+		}
+
+		if !send(msg) {
+			return
+		}
+	}
+}
+
+func (s *subscription) cancel() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.ps.Close()
+	})
+
+	return err
 }
 
 func (r *redisAdapter) Close() error {
 	return r.R.Close()
 }
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'E', -1, 64)
+}
+
+func formatScoreRange(min, max float64, minIncl, maxIncl bool) [2]string {
+	var rangeStr [2]string
+	ranges := []float64{min, max}
+	inclusive := []bool{minIncl, maxIncl}
+
+	for i := range ranges {
+		rangeStr[i] = formatScore(ranges[i])
+		if !inclusive[i] {
+			rangeStr[i] = fmt.Sprintf("(%s", rangeStr[i])
+		}
+	}
+
+	return rangeStr
+}
+
+func stringsResult(cmd *redis.Cmd) ([]string, error) {
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+
+	var members []string
+	val := cmd.Val().([]interface{})
+	for i := range val {
+		members = append(members, val[i].(string))
+	}
+
+	return members, nil
+}
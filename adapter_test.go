@@ -0,0 +1,104 @@
+package koda
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// fakePubSub delivers the messages sent on msgs, and fails to receive when
+// sent nil, as when the connection is lost.
+type fakePubSub struct {
+	msgs   chan *redis.Message
+	closed chan struct{}
+}
+
+func newFakePubSub() *fakePubSub {
+	return &fakePubSub{
+		msgs:   make(chan *redis.Message),
+		closed: make(chan struct{}),
+	}
+}
+
+func (ps *fakePubSub) ReceiveMessage() (*redis.Message, error) {
+	select {
+	case msg := <-ps.msgs:
+		if msg == nil {
+			return nil, errors.New("connection reset")
+		}
+		return msg, nil
+	case <-ps.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func (ps *fakePubSub) Close() error {
+	close(ps.closed)
+	return nil
+}
+
+func TestAdapterSubscribe(t *testing.T) {
+	ps := newFakePubSub()
+	ch, cancel := subscribe(ps)
+
+	ps.msgs <- &redis.Message{Channel: "foo", Payload: "a"}
+	if got := receive(t, ch); got != "a" {
+		t.Errorf("got %q, want %q", got, "a")
+	}
+
+	// Receive errors are retried
+	ps.msgs <- nil
+	ps.msgs <- &redis.Message{Channel: "foo", Payload: "b"}
+	if got := receive(t, ch); got != "b" {
+		t.Errorf("got %q, want %q", got, "b")
+	}
+
+	if err := cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cancel(); err != nil {
+		t.Fatal("second cancel:", err)
+	}
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("channel should be closed after cancelling")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel was not closed")
+	}
+}
+
+func TestAdapterPSubscribe(t *testing.T) {
+	ps := newFakePubSub()
+	ch, cancel := psubscribe(ps)
+	defer cancel()
+
+	ps.msgs <- &redis.Message{Channel: "foo:1", Payload: "a"}
+	select {
+	case msg := <-ch:
+		if msg[0] != "foo:1" || msg[1] != "a" {
+			t.Errorf("got %v, want [foo:1 a]", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestAdapterSubscribe_CancelWhileSending(t *testing.T) {
+	ps := newFakePubSub()
+	ch, cancel := subscribe(ps)
+
+	// The message is received, but never read from the channel
+	ps.msgs <- &redis.Message{Channel: "foo", Payload: "a"}
+	cancel()
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("channel was not closed")
+	}
+}
@@ -0,0 +1,155 @@
+package koda
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Batch is a group of jobs submitted together with Client.SubmitBatch. Once
+// every job in the batch has finished, died or been canceled, the batch's
+// callback job is queued, with the Batch as its payload.
+type Batch struct {
+	ID         int `json:"id"`
+	CallbackID int `json:"callback_id"`
+
+	// The number of jobs in the batch
+	Total int `json:"total"`
+
+	// The number of jobs yet to finish, die or be canceled
+	Pending int `json:"pending"`
+
+	Succeeded int `json:"succeeded"`
+	Dead      int `json:"dead"`
+	Canceled  int `json:"canceled"`
+}
+
+// BatchCallback describes the job queued once every job in a batch has
+// finished, died or been canceled.
+type BatchCallback struct {
+	Queue    Queue
+	Priority int
+}
+
+// SubmitBatch creates a job for each request, as SubmitMany does, and tracks
+// them as a batch. Once every job has finished, died or been canceled, a
+// callback job is put on callback.Queue, with the Batch as its payload. At
+// most MaxSubmitRequests jobs can be submitted at once.
+func (c *Client) SubmitBatch(queue Queue, reqs []SubmitRequest, callback BatchCallback) (Batch, []Job, error) {
+	return c.SubmitBatchContext(context.Background(), queue, reqs, callback)
+}
+
+// SubmitBatchContext creates a job for each request, as SubmitMany does, and
+// tracks them as a batch. Once every job has finished, died or been canceled,
+// a callback job is put on callback.Queue, with the Batch as its payload. The
+// jobs will carry any Metadata attached to ctx.
+func (c *Client) SubmitBatchContext(ctx context.Context, queue Queue, reqs []SubmitRequest, callback BatchCallback) (Batch, []Job, error) {
+	if err := ctx.Err(); err != nil {
+		return Batch{}, nil, err
+	}
+
+	if len(reqs) == 0 {
+		return Batch{}, nil, errors.New("batch has no jobs")
+	}
+
+	jobs, err := newJobs(ctx, queue, reqs)
+	if err != nil {
+		return Batch{}, nil, err
+	}
+
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	id, err := conn.Incr(c.buildKey("cur_batch_id"))
+	if err != nil {
+		return Batch{}, nil, err
+	}
+
+	for i := range jobs {
+		jobs[i].BatchID = id
+	}
+
+	// The callback job is allocated an ID along with the batch's jobs
+	jobs = append(jobs, Job{
+		Queue:    callback.Queue.Name,
+		Priority: callback.Priority,
+		State:    Initial,
+		Metadata: MetadataFromContext(ctx),
+	})
+
+	p := conn.Pipeline()
+	if err := c.pipelineNewJobs(conn, p, jobs); err != nil {
+		return Batch{}, nil, err
+	}
+
+	cb := jobs[len(jobs)-1]
+	jobs = jobs[:len(jobs)-1]
+
+	batch := Batch{
+		ID:         id,
+		CallbackID: cb.ID,
+		Total:      len(jobs),
+		Pending:    len(jobs),
+	}
+
+	p.HSetAll(c.batchKey(id), map[string]string{
+		"id":            strconv.Itoa(batch.ID),
+		"callback_id":   strconv.Itoa(batch.CallbackID),
+		"total":         strconv.Itoa(batch.Total),
+		"pending":       strconv.Itoa(batch.Pending),
+		"succeeded":     "0",
+		"dead":          "0",
+		"canceled":      "0",
+		"callback_key":  c.jobKey(cb.ID),
+		"callback_list": c.priorityQueueKey(cb.Queue, cb.Priority),
+		"callback_wake": c.wakeKey(cb.Queue),
+	})
+
+	if err := p.Exec(); err != nil {
+		return Batch{}, nil, err
+	}
+
+	return batch, jobs, nil
+}
+
+// Batch fetches a batch with the given batch ID.
+func (c *Client) Batch(id int) (Batch, error) {
+	return c.BatchContext(context.Background(), id)
+}
+
+// BatchContext fetches a batch with the given batch ID.
+func (c *Client) BatchContext(ctx context.Context, id int) (Batch, error) {
+	if err := ctx.Err(); err != nil {
+		return Batch{}, err
+	}
+
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	results, err := conn.HGetAll(c.batchKey(id))
+	if err != nil {
+		return Batch{}, err
+	}
+
+	if len(results) == 0 {
+		return Batch{}, ErrBatchNotFound
+	}
+
+	props := make(map[string]string)
+	for i := 0; i < len(results); i += 2 {
+		props[results[i]] = results[i+1]
+	}
+
+	u := jobUnmarshaller{Props: props}
+	batch := Batch{
+		ID:         u.atoi("id"),
+		CallbackID: u.atoi("callback_id"),
+		Total:      u.atoi("total"),
+		Pending:    u.atoi("pending"),
+		Succeeded:  u.atoi("succeeded"),
+		Dead:       u.atoi("dead"),
+		Canceled:   u.atoi("canceled"),
+	}
+
+	return batch, u.Err
+}
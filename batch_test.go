@@ -0,0 +1,69 @@
+package koda
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubmitBatch(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 1}
+	done := Queue{Name: "done"}
+
+	batch, jobs, err := client.SubmitBatch(q, []SubmitRequest{
+		{Priority: 100, Payload: 1},
+		{Priority: 100, Payload: 2},
+		{Priority: 100, Payload: 3},
+	}, BatchCallback{Queue: done, Priority: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if batch.Total != 3 || batch.Pending != 3 || len(jobs) != 3 {
+		t.Fatalf("unexpected batch: %+v", batch)
+	}
+
+	if _, err := client.CancelJob(jobs[2].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ := client.wait(q)
+	client.finish(&j, q)
+
+	if _, err := client.wait(done); err == nil {
+		t.Error("callback job was queued before the batch completed")
+	}
+
+	j, _ = client.wait(q)
+	client.fail(&j, q, errors.New("connection refused"))
+
+	cb, err := client.wait(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.ID != batch.CallbackID {
+		t.Errorf("id mismatch: %d != %d", cb.ID, batch.CallbackID)
+	}
+
+	var summary Batch
+	if err := cb.UnmarshalPayload(&summary); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Batch{ID: batch.ID, CallbackID: batch.CallbackID, Total: 3, Succeeded: 1, Dead: 1, Canceled: 1}
+	if summary != expected {
+		t.Errorf("summary mismatch: %+v != %+v", summary, expected)
+	}
+
+	if b, _ := client.Batch(batch.ID); b != expected {
+		t.Errorf("batch mismatch: %+v != %+v", b, expected)
+	}
+}
+
+func TestBatch_NotFound(t *testing.T) {
+	client := newTestClient()
+
+	if _, err := client.Batch(1); err != ErrBatchNotFound {
+		t.Errorf("expected ErrBatchNotFound, got %v", err)
+	}
+}
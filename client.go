@@ -1,8 +1,10 @@
 package koda
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/signal"
 	"strconv"
@@ -13,10 +15,17 @@ import (
 
 // HandlerFunc is used when registering a worker for a queue. If an error
 // is returned, the job will be marked as failed. If Job.NumAttempts exceeds
-// Queue.MaxAttempts, the job is placed in the Dead state. Otherwise it
-// is placed on the delayed queue with a delay of Queue.RetryInterval
+// Queue.MaxAttempts, or the error is wrapped with Permanent, the job is placed
+// in the Dead state. Otherwise it is placed on the delayed queue with a delay
+// determined by Queue.RetryPolicy, or by RetryAfter if the error is wrapped
+// with it.
 type HandlerFunc func(j *Job) error
 
+// ContextHandlerFunc is a HandlerFunc that also receives a context. The context
+// is cancelled when the worker is cancelled, or when the job's lease is lost.
+// Any Metadata the job was submitted with is attached to the context.
+type ContextHandlerFunc func(ctx context.Context, j *Job) error
+
 // DefaultClient is the Client used by the package-level functions.
 var DefaultClient = NewClient(nil)
 
@@ -24,8 +33,13 @@ var DefaultClient = NewClient(nil)
 // instantiate their own Client, but instead should use NewClient.
 type Client struct {
 	opts        *Options
+	workerID    string
 	connPool    sync.Pool
 	dispatchers []*dispatcher
+	scheduler   *scheduler
+
+	periodicJobs []periodicJob
+	periodicLock sync.Mutex
 }
 
 // Options for a Client.
@@ -38,21 +52,57 @@ type Options struct {
 	// Default: koda
 	Prefix string
 
+	// The duration a worker may go without a heartbeat before it is
+	// considered dead, and its in-flight jobs are returned to their queue.
+	// Default: 30s
+	WorkerTimeout time.Duration
+
+	// Called when a handler panics. The panic is recovered, and the attempt
+	// is considered failed.
+	// Default: nil
+	PanicHandler func(j *Job, err *PanicError)
+
 	ConnFactory func() Conn
 }
 
 // CreateJob will create a job in the Initial state.
-func (c *Client) CreateJob(payload interface{}) (Job, error) {
+func (c *Client) CreateJob(payload interface{}, opts ...SubmitOption) (Job, error) {
+	return c.CreateJobContext(context.Background(), payload, opts...)
+}
+
+// CreateJobContext will create a job in the Initial state. The job will
+// carry any Metadata attached to ctx.
+func (c *Client) CreateJobContext(ctx context.Context, payload interface{}, opts ...SubmitOption) (Job, error) {
+	if err := ctx.Err(); err != nil {
+		return Job{}, err
+	}
+
 	conn := c.getConn()
 	defer c.putConn(conn)
 
-	job := Job{payload: payload}
-	err := c.persistNewJob(&job, conn)
+	job := Job{
+		payload:  payload,
+		Metadata: MetadataFromContext(ctx),
+	}
+	for _, opt := range opts {
+		opt(&job)
+	}
+
+	_, err := c.persistNewJob(&job, conn, submission{})
 	return job, err
 }
 
 // Job fetches a job with the given job ID
 func (c *Client) Job(id int) (Job, error) {
+	return c.JobContext(context.Background(), id)
+}
+
+// JobContext fetches a job with the given job ID
+func (c *Client) JobContext(ctx context.Context, id int) (Job, error) {
+	if err := ctx.Err(); err != nil {
+		return Job{}, err
+	}
+
 	conn := c.getConn()
 	defer c.putConn(conn)
 
@@ -65,178 +115,1050 @@ func (c *Client) Job(id int) (Job, error) {
 }
 
 func (c *Client) persistJob(j *Job, conn Conn, fields ...string) error {
-	jobKey := c.jobKey(j.ID)
-	hash, err := j.hash()
+	hash, err := jobFields(j, fields...)
+	if err != nil {
+		return err
+	}
+
+	return conn.HSetAll(c.jobKey(j.ID), hash)
+}
+
+// submission describes the queue operations performed atomically alongside
+// persisting a job. See Conn.SubmitJob.
+type submission struct {
+	ListKey    string
+	WakeKey    string
+	DelayedKey string
+	Score      float64
+}
+
+func (c *Client) queueSubmission(queueName string, j *Job) submission {
+	return submission{
+		ListKey: c.priorityQueueKey(queueName, j.Priority),
+		WakeKey: c.wakeKey(queueName),
+	}
+}
+
+// delayedQueueSubmission truncates the job's DelayedUntil to the precision it
+// is stored with, so that it matches the job's score in the delayed queue.
+func (c *Client) delayedQueueSubmission(queueName string, j *Job) submission {
+	j.DelayedUntil = j.DelayedUntil.Truncate(timePrecision)
+	return submission{
+		DelayedKey: c.delayedQueueKey(queueName),
+		Score:      timeAsFloat(j.DelayedUntil),
+	}
+}
+
+// submitJob atomically persists the given fields of an existing job, and
+// performs the queue operations described by s.
+func (c *Client) submitJob(j *Job, conn Conn, s submission, fields ...string) error {
+	hash, err := jobFields(j, fields...)
 	if err != nil {
 		return err
 	}
 
-	if len(fields) == 0 {
-		for k := range hash {
-			fields = append(fields, k)
+	_, err = conn.SubmitJob("", j.ID, c.jobKeyPrefix(), hash, s.ListKey, s.WakeKey, s.DelayedKey, s.Score)
+	return err
+}
+
+// persistNewJob atomically allocates an ID for a new job, persists it, and
+// performs the queue operations described by s. If the job's UniqueKey, or
+// its debounce or throttle key, is held by an existing job, the existing job
+// is loaded into j instead, and created is false.
+func (c *Client) persistNewJob(j *Job, conn Conn, s submission) (created bool, err error) {
+	// Windows are stored as key expiries with millisecond precision, and a
+	// key that does not expire would hold the window forever
+	for _, w := range []*submitWindow{j.debounce, j.throttle} {
+		if w != nil && w.Window < timePrecision {
+			return false, fmt.Errorf("window must be at least %s: %s", timePrecision, w.Window)
 		}
 	}
 
-	out := make(map[string]string)
-	for _, f := range fields {
-		out[f] = hash[f]
+	j.CreationTime = time.Now().UTC().Truncate(timePrecision)
+
+	hash, err := jobFields(j)
+	if err != nil {
+		return false, err
+	}
+
+	idKey := c.buildKey("cur_job_id")
+	var id int
+	switch {
+	case j.debounce != nil && s.DelayedKey != "":
+		ttl := time.Until(j.DelayedUntil) + j.debounce.Window
+		if ttl < j.debounce.Window {
+			ttl = j.debounce.Window
+		}
+		update := []string{"delayed_until", "payload", "metadata"}
+		id, created, err = conn.DebounceJob(c.debounceKey(j.debounce.Key), ttl, idKey, c.jobKeyPrefix(), hash, update, s.DelayedKey, s.Score)
+	case j.throttle != nil:
+		id, created, err = conn.SubmitUniqueJob(c.throttleKey(j.throttle.Key), j.throttle.Window, idKey, c.jobKeyPrefix(), hash, s.ListKey, s.WakeKey, s.DelayedKey, s.Score)
+	case j.UniqueKey != "":
+		id, created, err = conn.SubmitUniqueJob(c.uniqueKey(j.UniqueKey), 0, idKey, c.jobKeyPrefix(), hash, s.ListKey, s.WakeKey, s.DelayedKey, s.Score)
+	default:
+		id, err = conn.SubmitJob(idKey, 0, c.jobKeyPrefix(), hash, s.ListKey, s.WakeKey, s.DelayedKey, s.Score)
+		created = true
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if !created {
+		existing, err := unmarshalJob(conn, c.jobKey(id))
+		if err != nil {
+			return false, fmt.Errorf("could not fetch job: %w", err)
+		}
+
+		*j = *existing
+		return false, nil
 	}
 
-	return conn.HSetAll(jobKey, out)
+	j.ID = id
+	c.publishEvent(conn, EventCreated, j, nil)
+	return true, nil
 }
 
-func (c *Client) addJobToQueue(queueName string, j *Job, conn Conn) error {
-	_, err := conn.RPush(c.priorityQueueKey(queueName, j.Priority), c.jobKey(j.ID))
+// releaseUniqueKey expires the UniqueKey of a job that has finished after
+// its UniqueTTL, so that the job may be submitted again.
+func (c *Client) releaseUniqueKey(j *Job, conn Conn) error {
+	if j.UniqueKey == "" {
+		return nil
+	}
+
+	_, err := conn.ExpireIfEqual(c.uniqueKey(j.UniqueKey), strconv.Itoa(j.ID), j.UniqueTTL)
 	return err
 }
 
 // Submit creates a job and puts it on the priority queue.
-func (c *Client) Submit(queue Queue, priority int, payload interface{}) (Job, error) {
+func (c *Client) Submit(queue Queue, priority int, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return c.SubmitContext(context.Background(), queue, priority, payload, opts...)
+}
+
+// SubmitContext creates a job and puts it on the priority queue. The job will
+// carry any Metadata attached to ctx.
+func (c *Client) SubmitContext(ctx context.Context, queue Queue, priority int, payload interface{}, opts ...SubmitOption) (Job, error) {
+	if err := ctx.Err(); err != nil {
+		return Job{}, err
+	}
+
 	conn := c.getConn()
 	defer c.putConn(conn)
 
 	j := Job{
 		payload:  payload,
+		Queue:    queue.Name,
 		Priority: priority,
 		State:    Queued,
+		Metadata: MetadataFromContext(ctx),
+	}
+	for _, opt := range opts {
+		opt(&j)
 	}
 
-	if err := c.persistNewJob(&j, conn); err != nil {
+	if err := c.submitNewJob(&j, conn); err != nil {
 		return Job{}, err
 	}
 
-	return j, c.addJobToQueue(queue.Name, &j, conn)
+	return j, nil
+}
+
+// submitNewJob persists a new job, and puts it on the delayed queue if its
+// DelayedUntil is set, or its priority queue otherwise. Debounced jobs are
+// delayed until the end of their window.
+func (c *Client) submitNewJob(j *Job, conn Conn) error {
+	if j.debounce != nil {
+		if t := time.Now().Add(j.debounce.Window).UTC(); t.After(j.DelayedUntil) {
+			j.DelayedUntil = t
+		}
+	}
+
+	event := EventQueued
+	s := c.queueSubmission(j.Queue, j)
+	if !j.DelayedUntil.IsZero() {
+		event = EventDelayed
+		s = c.delayedQueueSubmission(j.Queue, j)
+	}
+
+	created, err := c.persistNewJob(j, conn, s)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		c.publishEvent(conn, event, j, nil)
+	}
+
+	return nil
 }
 
 // SubmitJob puts an existing job on the priority queue.
 func (c *Client) SubmitJob(queue Queue, priority int, job Job) (Job, error) {
+	return c.SubmitJobContext(context.Background(), queue, priority, job)
+}
+
+// SubmitJobContext puts an existing job on the priority queue.
+func (c *Client) SubmitJobContext(ctx context.Context, queue Queue, priority int, job Job) (Job, error) {
 	conn := c.getConn()
 	defer c.putConn(conn)
 
-	job, err := c.Job(job.ID)
+	job, err := c.JobContext(ctx, job.ID)
 	if err != nil {
-		return Job{}, fmt.Errorf("could not fetch job: %s", err)
+		return Job{}, fmt.Errorf("could not fetch job: %w", err)
 	}
 
 	if job.State != Initial {
 		return Job{}, fmt.Errorf("invalid job state: %s", job.State)
 	}
 
+	job.Queue = queue.Name
 	job.Priority = priority
 	job.State = Queued
 
-	return job, c.addJobToQueue(queue.Name, &job, conn)
-}
+	s := c.queueSubmission(queue.Name, &job)
+	if err := c.submitJob(&job, conn, s, "queue", "priority", "state"); err != nil {
+		return job, err
+	}
 
-func (c *Client) addJobToDelayedQueue(queueName string, j *Job, conn Conn) error {
-	_, err := conn.ZAddNX(c.delayedQueueKey(queueName), timeAsFloat(j.DelayedUntil), c.jobKey(j.ID))
-	return err
+	c.publishEvent(conn, EventQueued, &job, nil)
+	return job, nil
 }
 
 // SubmitDelayed creates a job and puts it on the delayed queue.
-func (c *Client) SubmitDelayed(queue Queue, d time.Duration, payload interface{}) (Job, error) {
+func (c *Client) SubmitDelayed(queue Queue, d time.Duration, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return c.SubmitDelayedContext(context.Background(), queue, d, payload, opts...)
+}
+
+// SubmitDelayedContext creates a job and puts it on the delayed queue. The job
+// will carry any Metadata attached to ctx.
+func (c *Client) SubmitDelayedContext(ctx context.Context, queue Queue, d time.Duration, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return c.SubmitAtContext(ctx, queue, time.Now().Add(d), payload, opts...)
+}
+
+// SubmitAt creates a job and puts it on the delayed queue, to be processed at
+// time t.
+func (c *Client) SubmitAt(queue Queue, t time.Time, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return c.SubmitAtContext(context.Background(), queue, t, payload, opts...)
+}
+
+// SubmitAtContext creates a job and puts it on the delayed queue, to be
+// processed at time t. The job will carry any Metadata attached to ctx.
+func (c *Client) SubmitAtContext(ctx context.Context, queue Queue, t time.Time, payload interface{}, opts ...SubmitOption) (Job, error) {
+	if err := ctx.Err(); err != nil {
+		return Job{}, err
+	}
+
 	conn := c.getConn()
 	defer c.putConn(conn)
 
 	j := Job{
 		payload:      payload,
-		DelayedUntil: time.Now().Add(d).UTC(),
+		Queue:        queue.Name,
+		DelayedUntil: t.UTC(),
 		State:        Queued,
+		Metadata:     MetadataFromContext(ctx),
+	}
+	for _, opt := range opts {
+		opt(&j)
 	}
 
-	if err := c.persistNewJob(&j, conn); err != nil {
+	if err := c.submitNewJob(&j, conn); err != nil {
 		return Job{}, err
 	}
 
-	return j, c.addJobToDelayedQueue(queue.Name, &j, conn)
+	return j, nil
+}
+
+// MaxSubmitRequests is the maximum number of requests accepted by SubmitMany
+// and SubmitBatch. Larger submissions must be split by the caller.
+const MaxSubmitRequests = 1000
+
+// SubmitRequest describes a job created by SubmitMany.
+type SubmitRequest struct {
+	Priority int
+	Payload  interface{}
+
+	// If set, the job is put on the delayed queue, to be processed at this
+	// time
+	At time.Time
+
+	// WithUniqueKey, WithDebounce and WithThrottle are not supported
+	Options []SubmitOption
+}
+
+// SubmitMany creates a job for each request, and puts it on the priority
+// queue, or the delayed queue if the request's At is set. The jobs are
+// created atomically, with two round trips, regardless of their number. At
+// most MaxSubmitRequests jobs can be submitted at once.
+func (c *Client) SubmitMany(queue Queue, reqs []SubmitRequest) ([]Job, error) {
+	return c.SubmitManyContext(context.Background(), queue, reqs)
+}
+
+// SubmitManyContext creates a job for each request, and puts it on the
+// priority queue, or the delayed queue if the request's At is set. The jobs
+// will carry any Metadata attached to ctx.
+func (c *Client) SubmitManyContext(ctx context.Context, queue Queue, reqs []SubmitRequest) ([]Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	jobs, err := newJobs(ctx, queue, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	p := conn.Pipeline()
+	if err := c.pipelineNewJobs(conn, p, jobs); err != nil {
+		return nil, err
+	}
+
+	if err := p.Exec(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// newJobs creates a Queued job for each request.
+func newJobs(ctx context.Context, queue Queue, reqs []SubmitRequest) ([]Job, error) {
+	if len(reqs) > MaxSubmitRequests {
+		return nil, fmt.Errorf("too many requests: %d > %d", len(reqs), MaxSubmitRequests)
+	}
+
+	jobs := make([]Job, len(reqs))
+	for i, req := range reqs {
+		j := &jobs[i]
+		*j = Job{
+			payload:      req.Payload,
+			Queue:        queue.Name,
+			Priority:     req.Priority,
+			DelayedUntil: req.At.UTC(),
+			State:        Queued,
+			Metadata:     MetadataFromContext(ctx),
+		}
+		for _, opt := range req.Options {
+			opt(j)
+		}
+
+		if j.UniqueKey != "" || j.debounce != nil || j.throttle != nil {
+			return nil, errors.New("unique, debounced and throttled jobs can not be submitted in bulk")
+		}
+	}
+
+	return jobs, nil
+}
+
+// allocateJobs allocates IDs for new jobs with a single command, and returns
+// the fields of each job's hash.
+func (c *Client) allocateJobs(conn Conn, jobs []Job) ([]map[string]string, error) {
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now().UTC().Truncate(timePrecision)
+	hashes := make([]map[string]string, len(jobs))
+	for i := range jobs {
+		j := &jobs[i]
+		j.CreationTime = now
+		j.DelayedUntil = j.DelayedUntil.Truncate(timePrecision)
+
+		hash, err := jobFields(j)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+
+	last, err := conn.IncrBy(c.buildKey("cur_job_id"), len(jobs))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs {
+		jobs[i].ID = last - len(jobs) + i + 1
+		hashes[i]["id"] = strconv.Itoa(jobs[i].ID)
+	}
+
+	return hashes, nil
+}
+
+// pipelineNewJobs allocates IDs for new jobs, then queues commands on p that
+// persist each job, put it on the delayed queue if its DelayedUntil is set,
+// or its priority queue otherwise, and publish its events. Jobs in the
+// Initial state are not put on a queue.
+func (c *Client) pipelineNewJobs(conn Conn, p Pipeline, jobs []Job) error {
+	hashes, err := c.allocateJobs(conn, jobs)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		j := &jobs[i]
+		key := c.jobKey(j.ID)
+		p.HSetAll(key, hashes[i])
+
+		events := []EventType{EventCreated}
+		switch {
+		case j.State == Initial:
+		case !j.DelayedUntil.IsZero():
+			s := c.delayedQueueSubmission(j.Queue, j)
+			p.ZAdd(s.DelayedKey, s.Score, key)
+			p.Publish(c.scheduledChannel(j.Queue), strconv.Itoa(j.ID))
+			events = append(events, EventDelayed)
+		default:
+			s := c.queueSubmission(j.Queue, j)
+			p.RPush(s.ListKey, key)
+			p.RPush(s.WakeKey, key)
+			events = append(events, EventQueued)
+		}
+
+		for _, t := range events {
+			if msg, ok := eventMessage(t, j, nil); ok {
+				p.Publish(c.eventsChannel(), msg)
+			}
+		}
+	}
+
+	return nil
 }
 
 // SubmitDelayedJob puts an existing job on the delayed queue.
 func (c *Client) SubmitDelayedJob(queue Queue, d time.Duration, job Job) (Job, error) {
+	return c.SubmitDelayedJobContext(context.Background(), queue, d, job)
+}
+
+// SubmitDelayedJobContext puts an existing job on the delayed queue.
+func (c *Client) SubmitDelayedJobContext(ctx context.Context, queue Queue, d time.Duration, job Job) (Job, error) {
+	return c.SubmitDelayedJobAtContext(ctx, queue, time.Now().Add(d), job)
+}
+
+// SubmitDelayedJobAt puts an existing job on the delayed queue, to be
+// processed at time t.
+func (c *Client) SubmitDelayedJobAt(queue Queue, t time.Time, job Job) (Job, error) {
+	return c.SubmitDelayedJobAtContext(context.Background(), queue, t, job)
+}
+
+// SubmitDelayedJobAtContext puts an existing job on the delayed queue, to be
+// processed at time t.
+func (c *Client) SubmitDelayedJobAtContext(ctx context.Context, queue Queue, t time.Time, job Job) (Job, error) {
 	conn := c.getConn()
 	defer c.putConn(conn)
 
-	job, err := c.Job(job.ID)
+	job, err := c.JobContext(ctx, job.ID)
 	if err != nil {
-		return Job{}, fmt.Errorf("could not fetch job: %s", err)
+		return Job{}, fmt.Errorf("could not fetch job: %w", err)
 	}
 
 	if job.State != Initial {
 		return Job{}, fmt.Errorf("invalid job state: %s", job.State)
 	}
 
-	job.DelayedUntil = time.Now().Add(d).UTC()
+	job.Queue = queue.Name
+	job.DelayedUntil = t.UTC()
 	job.State = Queued
 
-	return job, c.addJobToDelayedQueue(queue.Name, &job, conn)
+	s := c.delayedQueueSubmission(queue.Name, &job)
+	if err := c.submitJob(&job, conn, s, "queue", "delayed_until", "state"); err != nil {
+		return job, err
+	}
+
+	c.publishEvent(conn, EventDelayed, &job, nil)
+	return job, nil
 }
 
-// Register a HandlerFunc for a given Queue
-func (c *Client) Register(queue Queue, f HandlerFunc) {
-	if queue.MaxAttempts < 1 {
-		queue.MaxAttempts = 1
+// CancelJob removes a Queued job from its queue, and places it in the
+// Canceled state.
+func (c *Client) CancelJob(id int) (Job, error) {
+	return c.CancelJobContext(context.Background(), id)
+}
+
+// CancelJobContext removes a Queued job from its queue, and places it in the
+// Canceled state.
+func (c *Client) CancelJobContext(ctx context.Context, id int) (Job, error) {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	job, err := c.JobContext(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not fetch job: %w", err)
+	}
+
+	if job.State != Queued {
+		return Job{}, fmt.Errorf("invalid job state: %s", job.State)
+	}
+
+	if job.Queue == "" {
+		return Job{}, errors.New("job's queue is unknown")
+	}
+
+	job.State = Canceled
+	job.CompletionTime = time.Now().UTC()
+
+	cmp := completion{
+		States:   []string{strconv.Itoa(Queued)},
+		FromList: c.priorityQueueKey(job.Queue, job.Priority),
+		FromZSet: c.delayedQueueKey(job.Queue),
+	}
+
+	ok, err := c.completeJob(&job, conn, cmp, nil, "canceled", "state", "completion_time")
+	if err != nil {
+		return Job{}, err
+	}
+
+	if !ok {
+		return Job{}, errors.New("invalid job state: job is no longer queued")
+	}
+
+	if err := c.releaseUniqueKey(&job, conn); err != nil {
+		return Job{}, err
+	}
+
+	c.publishEvent(conn, EventCanceled, &job, nil)
+	return job, nil
+}
+
+// MoveJob atomically moves a Queued or Dead job to another queue, with the
+// given priority. Dead jobs are queued again, with their attempts reset, and
+// are no longer part of their batch. Dead jobs with a UniqueKey can not be
+// moved, as their key has been released.
+func (c *Client) MoveJob(id int, queue Queue, priority int) (Job, error) {
+	return c.MoveJobContext(context.Background(), id, queue, priority)
+}
+
+// MoveJobContext atomically moves a Queued or Dead job to another queue, with
+// the given priority. Dead jobs are queued again, with their attempts reset,
+// and are no longer part of their batch. Dead jobs with a UniqueKey can not be
+// moved, as their key has been released.
+func (c *Client) MoveJobContext(ctx context.Context, id int, queue Queue, priority int) (Job, error) {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	from, err := c.JobContext(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not fetch job: %w", err)
+	}
+
+	job := from
+	job.Queue = queue.Name
+	job.Priority = priority
+	fields := []string{"queue", "priority"}
+
+	switch from.State {
+	case Queued:
+	case Dead:
+		// Another job may now hold the key, which would be duplicated
+		if from.UniqueKey != "" {
+			return Job{}, errors.New("dead jobs with a unique key can not be moved")
+		}
+
+		// The job was already counted towards its batch, so it leaves the
+		// batch
+		job.State = Queued
+		job.NumAttempts = 0
+		job.BatchID = 0
+		fields = append(fields, "state", "num_attempts", "batch_id")
+	default:
+		return Job{}, fmt.Errorf("invalid job state: %s", from.State)
+	}
+
+	if err := c.requeue(conn, from, &job, fields...); err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// SetPriority atomically changes the priority of a Queued job.
+func (c *Client) SetPriority(id int, priority int) (Job, error) {
+	return c.SetPriorityContext(context.Background(), id, priority)
+}
+
+// SetPriorityContext atomically changes the priority of a Queued job.
+func (c *Client) SetPriorityContext(ctx context.Context, id int, priority int) (Job, error) {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	from, err := c.JobContext(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not fetch job: %w", err)
 	}
-	if queue.NumWorkers < 1 {
-		queue.NumWorkers = 1
+
+	if from.State != Queued {
+		return Job{}, fmt.Errorf("invalid job state: %s", from.State)
 	}
 
+	job := from
+	job.Priority = priority
+	if err := c.requeue(conn, from, &job, "priority"); err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// Reschedule atomically changes the time a Queued job will be processed. If t
+// is not in the future, the job is put on the priority queue.
+func (c *Client) Reschedule(id int, t time.Time) (Job, error) {
+	return c.RescheduleContext(context.Background(), id, t)
+}
+
+// RescheduleContext atomically changes the time a Queued job will be
+// processed. If t is not in the future, the job is put on the priority queue.
+func (c *Client) RescheduleContext(ctx context.Context, id int, t time.Time) (Job, error) {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	from, err := c.JobContext(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not fetch job: %w", err)
+	}
+
+	if from.State != Queued {
+		return Job{}, fmt.Errorf("invalid job state: %s", from.State)
+	}
+
+	job := from
+	job.DelayedUntil = t.UTC()
+	if err := c.requeue(conn, from, &job, "delayed_until"); err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// requeue atomically removes a job from its queue, if it is Queued, persists
+// the given fields of the job, and puts it on the queue named by job.Queue.
+// The job is put on the delayed queue if its DelayedUntil is in the future.
+// from is the job as it was fetched, which must still be in the same state.
+func (c *Client) requeue(conn Conn, from Job, job *Job, fields ...string) error {
+	var fromList, fromZSet string
+	if from.State == Queued {
+		if from.Queue == "" {
+			return errors.New("job's queue is unknown")
+		}
+
+		fromList = c.priorityQueueKey(from.Queue, from.Priority)
+		fromZSet = c.delayedQueueKey(from.Queue)
+	}
+
+	event := EventQueued
+	s := c.queueSubmission(job.Queue, job)
+	if job.DelayedUntil.After(time.Now()) {
+		event = EventDelayed
+		s = c.delayedQueueSubmission(job.Queue, job)
+	}
+
+	hash, err := jobFields(job, fields...)
+	if err != nil {
+		return err
+	}
+
+	ok, err := conn.MoveJob(
+		c.jobKey(job.ID),
+		[]string{strconv.Itoa(int(from.State))},
+		fromList,
+		fromZSet,
+		s.ListKey,
+		s.WakeKey,
+		s.DelayedKey,
+		s.Score,
+		hash,
+		from.State == Queued)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("invalid job state: job is no longer %s", strings.ToLower(from.State.String()))
+	}
+
+	c.publishEvent(conn, event, job, nil)
+	return nil
+}
+
+// Register a HandlerFunc for a given Queue
+func (c *Client) Register(queue Queue, f HandlerFunc) {
+	c.RegisterContext(queue, func(ctx context.Context, j *Job) error {
+		return f(j)
+	})
+}
+
+// RegisterContext registers a ContextHandlerFunc for a given Queue
+func (c *Client) RegisterContext(queue Queue, f ContextHandlerFunc) {
 	c.dispatchers = append(c.dispatchers, &dispatcher{
-		Queue:   queue,
+		Queue:   queue.withDefaults(),
 		Handler: f,
 	})
 }
 
-func (c *Client) retry(j *Job, queue Queue) error {
+// fail retries a job whose attempt failed with err, or kills the job if it
+// should not be retried.
+func (c *Client) fail(j *Job, queue Queue, err error) error {
+	j.endAttempt(err)
+
+	conn := c.getConn()
+	c.publishEvent(conn, EventFailed, j, err)
+	c.putConn(conn)
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || j.NumAttempts >= queue.MaxAttempts {
+		return c.kill(j, queue)
+	}
+
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) {
+		return c.retry(j, queue, retryAfter.delay)
+	}
+
+	policy := queue.RetryPolicy
+	if policy == nil {
+		policy = ConstantBackoff{Interval: queue.RetryInterval}
+	}
+
+	delay, ok := policy.NextRetry(j, j.NumAttempts, err)
+	if !ok {
+		return c.kill(j, queue)
+	}
+
+	return c.retry(j, queue, delay)
+}
+
+func (c *Client) retry(j *Job, queue Queue, delay time.Duration) error {
 	conn := c.getConn()
 	defer c.putConn(conn)
 
 	j.State = Queued
-	j.DelayedUntil = time.Now().UTC().Add(queue.RetryInterval)
+	j.DelayedUntil = time.Now().UTC().Add(delay)
+	j.RetryDelay = delay
+	j.LeaseExpiry = time.Time{}
 
-	if err := c.persistJob(j, conn, "state", "delayed_until"); err != nil {
+	s := c.delayedQueueSubmission(queue.Name, j)
+	hash, err := jobFields(j, "state", "delayed_until", "retry_delay", "lease_expiry", "timed_out", "attempts", "last_error")
+	if err != nil {
+		return err
+	}
+
+	// The job is only retried if it is still held by this worker
+	ok, err := conn.MoveJob(
+		c.jobKey(j.ID),
+		[]string{strconv.Itoa(Working), strconv.Itoa(Queued)},
+		"",
+		c.processingKey(queue.Name, c.workerID),
+		"",
+		"",
+		s.DelayedKey,
+		s.Score,
+		hash,
+		true)
+
+	if err != nil {
 		return err
 	}
 
-	return c.addJobToDelayedQueue(queue.Name, j, conn)
+	if !ok {
+		return ErrLeaseExpired
+	}
+
+	c.publishEvent(conn, EventRetried, j, nil)
+	return nil
 }
 
-func (c *Client) finish(j *Job) error {
+func (c *Client) finish(j *Job, queue Queue) error {
 	conn := c.getConn()
 	defer c.putConn(conn)
 
 	j.State = Finished
 	j.CompletionTime = time.Now().UTC()
+	j.LeaseExpiry = time.Time{}
+	j.endAttempt(nil)
+
+	ok, err := c.completeJob(j, conn, c.heldCompletion(queue), j.onSuccess, "succeeded", "state", "completion_time", "lease_expiry", "attempts")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrLeaseExpired
+	}
 
-	return c.persistJob(j, conn, "state", "completion_time")
+	if err := c.releaseUniqueKey(j, conn); err != nil {
+		return err
+	}
+
+	c.publishEvent(conn, EventSucceeded, j, nil)
+	return nil
 }
 
-func (c *Client) kill(j *Job) error {
+func (c *Client) kill(j *Job, queue Queue) error {
 	conn := c.getConn()
 	defer c.putConn(conn)
 
 	j.State = Dead
-	return c.persistJob(j, conn, "state")
+	j.LeaseExpiry = time.Time{}
+
+	var followUps []followUp
+	if j.onDeath != nil {
+		followUps = append(followUps, *j.onDeath)
+	}
+
+	ok, err := c.completeJob(j, conn, c.heldCompletion(queue), followUps, "dead", "state", "lease_expiry", "timed_out", "attempts", "last_error")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrLeaseExpired
+	}
+
+	if err := c.releaseUniqueKey(j, conn); err != nil {
+		return err
+	}
+
+	c.publishEvent(conn, EventDead, j, nil)
+	return nil
+}
+
+// cancel places a running job in the Canceled state.
+func (c *Client) cancel(j *Job, queue Queue) error {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	j.State = Canceled
+	j.CompletionTime = time.Now().UTC()
+	j.LeaseExpiry = time.Time{}
+	j.endAttempt(ErrJobCanceled)
+
+	ok, err := c.completeJob(j, conn, c.heldCompletion(queue), nil, "canceled", "state", "completion_time", "lease_expiry", "attempts", "last_error")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrLeaseExpired
+	}
+
+	if err := c.releaseUniqueKey(j, conn); err != nil {
+		return err
+	}
+
+	c.publishEvent(conn, EventCanceled, j, nil)
+	return nil
+}
+
+// quarantine moves a job that can not be unmarshalled from the sorted set
+// fromKey to the quarantine set, so that it is not claimed again.
+func (c *Client) quarantine(jobKey string, fromKey string, conn Conn) error {
+	if _, err := conn.ZAdd(c.quarantineKey(), timeAsFloat(time.Now().UTC()), jobKey); err != nil {
+		return err
+	}
+
+	_, err := conn.ZRem(fromKey, jobKey)
+	return err
+}
+
+func isCorrupt(err error) bool {
+	var corruptErr *CorruptJobError
+	return errors.As(err, &corruptErr) || errors.Is(err, ErrJobNotFound)
+}
+
+// heldCompletion guards a completion on the job still being one of this
+// worker's in-flight jobs, which it is removed from. A job whose lease expired
+// may have been reclaimed by another worker, and must not be completed twice.
+func (c *Client) heldCompletion(queue Queue) completion {
+	return completion{FromZSet: c.processingKey(queue.Name, c.workerID)}
+}
+
+// release removes a job from the worker's in-flight jobs.
+func (c *Client) release(j *Job, queue Queue, conn Conn) error {
+	_, err := conn.ZRem(c.processingKey(queue.Name, c.workerID), c.jobKey(j.ID))
+	return err
+}
+
+// renewLease extends the lease of a job claimed by this worker. If the lease
+// has already been lost to another worker, ok will be false.
+func (c *Client) renewLease(j *Job, queue Queue) (ok bool, err error) {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	leaseExpiry := time.Now().UTC().Add(queue.VisibilityTimeout)
+	n, err := conn.ZAddXX(c.processingKey(queue.Name, c.workerID), timeAsFloat(leaseExpiry), c.jobKey(j.ID))
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	j.LeaseExpiry = leaseExpiry
+	return true, c.persistJob(j, conn, "lease_expiry")
 }
 
-func (c *Client) popJob(conn Conn, delayedQueueKey string, priorityQueues ...string) (string, error) {
-	results, err := conn.ZPopByScore(
-		delayedQueueKey,
+// reclaimExpired moves jobs with expired leases from every worker of the
+// queue into this worker's in-flight jobs, then fails each of them.
+func (c *Client) reclaimExpired(queue Queue, conn Conn) error {
+	workerIDs, err := conn.ZRangeByScore(
+		c.workersKey(queue.Name),
+		math.Inf(-1),
+		math.Inf(1),
+		true,
+		true,
+		0,
+		-1)
+
+	if err != nil {
+		return err
+	}
+
+	now := timeAsFloat(time.Now().UTC())
+	for _, workerID := range workerIDs {
+		jobKeys, err := conn.ZPopByScoreZAdd(
+			c.processingKey(queue.Name, workerID),
+			math.Inf(-1),
+			now,
+			true,
+			false,
+			0,
+			-1,
+			c.processingKey(queue.Name, c.workerID),
+			now)
+
+		if err != nil {
+			return err
+		}
+
+		if err := c.recoverJobs(queue, jobKeys, ErrLeaseExpired, conn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recoverJobs handles jobs moved into this worker's in-flight jobs from a
+// worker that lost them. Working jobs are failed with reason, so that the
+// queue's retry policy applies. Jobs that were claimed but never started are
+// returned to their queue, and jobs that have since completed are released.
+func (c *Client) recoverJobs(queue Queue, jobKeys []string, reason error, conn Conn) error {
+	processingKey := c.processingKey(queue.Name, c.workerID)
+	for _, jobKey := range jobKeys {
+		j, err := unmarshalJob(conn, jobKey)
+		if isCorrupt(err) {
+			if err := c.quarantine(jobKey, processingKey, conn); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		switch j.State {
+		case Working:
+			err = c.fail(j, queue, reason)
+		case Queued:
+			var ok bool
+			ok, err = conn.MoveJob(
+				jobKey,
+				[]string{strconv.Itoa(Queued)},
+				"",
+				processingKey,
+				c.priorityQueueKey(queue.Name, j.Priority),
+				c.wakeKey(queue.Name),
+				"",
+				0,
+				nil,
+				true)
+
+			if ok {
+				c.publishEvent(conn, EventQueued, j, reason)
+			}
+		default:
+			err = c.release(j, queue, conn)
+		}
+
+		if err != nil && err != ErrLeaseExpired {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// heartbeat registers the client as a live worker of the given queue, and
+// returns the in-flight jobs of any dead workers back to the queue.
+func (c *Client) heartbeat(queue Queue) error {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	now := time.Now().UTC()
+	if _, err := conn.ZAdd(c.workersKey(queue.Name), timeAsFloat(now), c.workerID); err != nil {
+		return err
+	}
+
+	workerIDs, err := conn.ZRangeByScore(
+		c.workersKey(queue.Name),
 		0,
-		timeAsFloat(time.Now().UTC()),
+		timeAsFloat(now.Add(-c.opts.WorkerTimeout)),
+		true,
+		false,
+		0,
+		-1)
+
+	if err != nil {
+		return err
+	}
+
+	for _, workerID := range workerIDs {
+		if err := c.unregister(queue, workerID, conn); err != nil {
+			return err
+		}
+	}
+
+	return c.reclaimExpired(queue, conn)
+}
+
+// unregister recovers all in-flight jobs of a worker, as reclaimExpired does,
+// and removes the worker from the queue's list of live workers.
+func (c *Client) unregister(queue Queue, workerID string, conn Conn) error {
+	jobKeys, err := conn.ZPopByScoreZAdd(
+		c.processingKey(queue.Name, workerID),
+		math.Inf(-1),
+		math.Inf(1),
 		true,
 		true,
 		0,
-		1)
+		-1,
+		c.processingKey(queue.Name, c.workerID),
+		timeAsFloat(time.Now().UTC()))
 
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if len(results) > 0 {
-		return results[0], nil
+	if err := c.recoverJobs(queue, jobKeys, ErrWorkerLost, conn); err != nil {
+		return err
 	}
 
-	results, err = conn.BLPop(1*time.Second, priorityQueues...)
+	_, err = conn.ZRem(c.workersKey(queue.Name), workerID)
+	return err
+}
+
+func (c *Client) stopWorking(queue Queue) error {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	return c.unregister(queue, c.workerID, conn)
+}
+
+// popJob atomically moves the next available job into processingKey, so the
+// job can be recovered if the worker dies while processing it. Delayed jobs
+// are moved onto the priority queues by the scheduler once they are due.
+func (c *Client) popJob(conn Conn, wakeKey, processingKey string, leaseExpiry time.Time, priorityQueues ...string) (string, error) {
+	results, err := conn.ClaimJob(1*time.Second, wakeKey, processingKey, timeAsFloat(leaseExpiry), priorityQueues...)
 	if err != nil {
 		return "", err
 	}
@@ -261,7 +1183,13 @@ func (c *Client) wait(queue Queue) (Job, error) {
 		}
 	}
 
-	jobKey, err := c.popJob(conn, c.delayedQueueKey(queue.Name), queue.queueKeys...)
+	leaseExpiry := time.Now().UTC().Add(queue.VisibilityTimeout)
+	jobKey, err := c.popJob(
+		conn,
+		c.wakeKey(queue.Name),
+		c.processingKey(queue.Name, c.workerID),
+		leaseExpiry,
+		queue.queueKeys...)
 	if jobKey == "" {
 		return Job{}, errors.New("not found")
 	}
@@ -271,37 +1199,200 @@ func (c *Client) wait(queue Queue) (Job, error) {
 
 	j, err := unmarshalJob(conn, jobKey)
 	if err != nil {
-		fmt.Println("error while unmarshaling job", err)
+		if isCorrupt(err) {
+			c.quarantine(jobKey, c.processingKey(queue.Name, c.workerID), conn)
+		}
 		return Job{}, err
 	}
 
+	// The job may have left the queue after its key was pushed, such as by
+	// being canceled, in which case it must not be run
+	if j.State != Queued {
+		c.release(j, queue, conn)
+		return Job{}, fmt.Errorf("invalid job state: %s", j.State)
+	}
+
 	j.State = Working
 	j.NumAttempts++
+	j.LeaseExpiry = leaseExpiry
+	j.TimedOut = false
+	j.startAttempt(c.workerID)
 
-	c.persistJob(j, conn, "state", "num_attempts")
-
+	c.persistJob(j, conn, "state", "start_time", "num_attempts", "lease_expiry", "timed_out", "attempts")
+	c.publishEvent(conn, EventStarted, j, nil)
 	return *j, nil
 }
 
+// CancelRunning requests that the worker processing a Working job cancel it.
+// The context given to the job's handler is cancelled, and the job is placed
+// in the Canceled state. CancelRunning does not wait for the handler to return.
+func (c *Client) CancelRunning(id int) error {
+	return c.CancelRunningContext(context.Background(), id)
+}
+
+// CancelRunningContext requests that the worker processing a Working job
+// cancel it. The context given to the job's handler is cancelled, and the job
+// is placed in the Canceled state. CancelRunningContext does not wait for the
+// handler to return.
+func (c *Client) CancelRunningContext(ctx context.Context, id int) error {
+	job, err := c.JobContext(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not fetch job: %w", err)
+	}
+
+	if job.State != Working || len(job.Attempts) == 0 {
+		return fmt.Errorf("invalid job state: %s", job.State)
+	}
+
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	workerID := job.Attempts[len(job.Attempts)-1].WorkerID
+	n, err := conn.Publish(c.cancelChannel(workerID), strconv.Itoa(job.ID))
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("worker %s is not running", workerID)
+	}
+
+	return nil
+}
+
+// subscribeRetryInterval is how long to wait before subscribing to the cancel
+// channel again, after failing to subscribe
+const subscribeRetryInterval = time.Second
+
+// listenForCancellations cancels jobs processed by this worker when requested
+// by CancelRunning, until the returned function is called. A failed
+// subscription is retried, as the worker would otherwise never honour
+// CancelRunning.
+func (c *Client) listenForCancellations() func() error {
+	unsubscribe, err := c.subscribeCancellations()
+	if err == nil {
+		return unsubscribe
+	}
+
+	var lock sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(subscribeRetryInterval):
+			}
+
+			lock.Lock()
+			select {
+			case <-done:
+				lock.Unlock()
+				return
+			default:
+			}
+			unsubscribe, err = c.subscribeCancellations()
+			lock.Unlock()
+
+			if err == nil {
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		close(done)
+		if unsubscribe != nil {
+			return unsubscribe()
+		}
+
+		return nil
+	}
+}
+
+// subscribeCancellations cancels jobs processed by this worker when requested
+// by CancelRunning, until the returned function is called.
+func (c *Client) subscribeCancellations() (func() error, error) {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	ch, unsubscribe, err := conn.Subscribe(c.cancelChannel(c.workerID))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for msg := range ch {
+			id, err := strconv.Atoi(msg)
+			if err != nil {
+				continue
+			}
+
+			for _, d := range c.dispatchers {
+				d.jobManager.Cancel(id)
+			}
+		}
+	}()
+
+	return unsubscribe, nil
+}
+
 // Work will begin processing any registered queues in a separate goroutine.
 // Use returned Canceller to stop any outstanding workers.
 func (c *Client) Work() Canceller {
+	return c.WorkContext(context.Background())
+}
+
+// WorkContext will begin processing any registered queues in a separate
+// goroutine. Outstanding workers are cancelled when ctx is done, or by using
+// the returned Canceller.
+func (c *Client) WorkContext(ctx context.Context) Canceller {
 	for _, d := range c.dispatchers {
 		d.client = c
 		d.Run()
 	}
 
-	return &canceller{dispatchers: c.dispatchers}
+	unsubscribe := c.listenForCancellations()
+
+	// Periodic jobs may be registered while working
+	periodicCtx, stopPeriodic := context.WithCancel(context.Background())
+	go c.runPeriodic(periodicCtx)
+
+	canceller := &canceller{
+		dispatchers:  c.dispatchers,
+		unsubscribe:  unsubscribe,
+		stopPeriodic: stopPeriodic,
+	}
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			canceller.Cancel()
+		}()
+	}
+
+	return canceller
 }
 
 // WorkForever will being processing registered queues. This routine will
 // block until SIGINT is received.
 func (c *Client) WorkForever() {
+	c.WorkForeverContext(context.Background())
+}
+
+// WorkForeverContext will being processing registered queues. This routine
+// will block until SIGINT is received, or ctx is done.
+func (c *Client) WorkForeverContext(ctx context.Context) {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 	canceller := c.Work()
 
-	<-sig
+	select {
+	case <-sig:
+	case <-ctx.Done():
+	}
 	signal.Stop(sig)
 	canceller.CancelWithTimeout(0)
 }
@@ -314,14 +1405,30 @@ type Canceller interface {
 }
 
 type canceller struct {
-	dispatchers []*dispatcher
+	dispatchers  []*dispatcher
+	unsubscribe  func() error
+	stopPeriodic context.CancelFunc
+	once         sync.Once
 }
 
 func (c *canceller) Cancel() {
 	c.CancelWithTimeout(0)
 }
 
+// CancelWithTimeout cancels the dispatchers the first time it is called.
+// Subsequent calls block until the first has completed.
 func (c *canceller) CancelWithTimeout(d time.Duration) {
+	c.once.Do(func() { c.cancel(d) })
+}
+
+func (c *canceller) cancel(d time.Duration) {
+	if c.unsubscribe != nil {
+		defer c.unsubscribe()
+	}
+	if c.stopPeriodic != nil {
+		c.stopPeriodic()
+	}
+
 	n := len(c.dispatchers)
 	if n == 0 {
 		return
@@ -360,30 +1467,70 @@ func timeAsFloat(t time.Time) float64 {
 	return float64(t.UTC().UnixNano()) / float64(time.Second)
 }
 
-func (c *Client) persistNewJob(j *Job, conn Conn) error {
-	id, err := c.incrJobID(conn)
-	if err != nil {
-		return err
-	}
-
-	j.ID = id
-	j.CreationTime = time.Now().UTC()
-
-	return c.persistJob(j, conn)
+func (c *Client) priorityQueueKey(queueName string, priority int) string {
+	return c.priorityQueueKeyPrefix(queueName) + strconv.Itoa(priority)
 }
 
-func (c *Client) priorityQueueKey(queueName string, priority int) string {
-	return c.buildKey("queue", queueName, strconv.Itoa(priority))
+func (c *Client) priorityQueueKeyPrefix(queueName string) string {
+	return c.buildKey("queue", queueName, "")
 }
 
 func (c *Client) delayedQueueKey(queueName string) string {
 	return c.buildKey("delayed_queue", queueName)
 }
 
+func (c *Client) processingKey(queueName string, workerID string) string {
+	return c.buildKey("processing", queueName, workerID)
+}
+
+func (c *Client) wakeKey(queueName string) string {
+	return c.buildKey("wake", queueName)
+}
+
+func (c *Client) scheduledChannel(queueName string) string {
+	return c.buildKey("scheduled", queueName)
+}
+
+func (c *Client) workersKey(queueName string) string {
+	return c.buildKey("workers", queueName)
+}
+
+func (c *Client) cancelChannel(workerID string) string {
+	return c.buildKey("cancel", workerID)
+}
+
+func (c *Client) eventsChannel() string {
+	return c.buildKey("events")
+}
+
+func (c *Client) debounceKey(key string) string {
+	return c.buildKey("debounce", key)
+}
+
+func (c *Client) throttleKey(key string) string {
+	return c.buildKey("throttle", key)
+}
+
+func (c *Client) uniqueKey(key string) string {
+	return c.buildKey("unique", key)
+}
+
+func (c *Client) periodicKey(queueName string, id string) string {
+	return c.buildKey("periodic", queueName, id)
+}
+
+func (c *Client) batchKey(id int) string {
+	return c.buildKey("batches", strconv.Itoa(id))
+}
+
+func (c *Client) quarantineKey() string {
+	return c.buildKey("quarantine")
+}
+
 func (c *Client) jobKey(id int) string {
-	return c.buildKey("jobs", strconv.Itoa(id))
+	return c.jobKeyPrefix() + strconv.Itoa(id)
 }
 
-func (c *Client) incrJobID(conn Conn) (int, error) {
-	return conn.Incr(c.buildKey("cur_job_id"))
+func (c *Client) jobKeyPrefix() string {
+	return c.buildKey("jobs", "")
 }
@@ -1,7 +1,12 @@
 package koda
 
 import (
+	"context"
+	"errors"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -44,6 +49,15 @@ func testJobSubmission(t *testing.T, submitFunc func(client *Client, job Job) (J
 	if job.State != Queued {
 		t.Error("unexpected job state:", job.State)
 	}
+
+	job, err = client.Job(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.State != Queued {
+		t.Error("unexpected persisted job state:", job.State)
+	}
 }
 
 func TestSubmitJob(t *testing.T) {
@@ -58,6 +72,33 @@ func TestSubmitDelayedJob(t *testing.T) {
 	})
 }
 
+func TestSubmitDelayedJobAt(t *testing.T) {
+	testJobSubmission(t, func(client *Client, job Job) (Job, error) {
+		return client.SubmitDelayedJobAt(Queue{Name: "q"}, time.Now(), job)
+	})
+}
+
+func TestSubmitAt(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	at := time.Date(2030, 1, 1, 9, 0, 0, 0, loc)
+	job, err := client.SubmitAt(q, at, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ := client.Job(job.ID)
+	if !j.DelayedUntil.Equal(at) {
+		t.Errorf("delayed until mismatch: %s != %s", j.DelayedUntil, at)
+	}
+
+	if _, err := client.wait(q); err == nil {
+		t.Error("job should not be processed before its time")
+	}
+}
+
 func TestWork(t *testing.T) {
 	client := newTestClient()
 	q := Queue{Name: "q"}
@@ -122,3 +163,876 @@ func TestWorkForever(t *testing.T) {
 		t.Fatal("job should be readded to queue")
 	}
 }
+
+func TestHeartbeat_DeadWorker(t *testing.T) {
+	opts := optionsWithMock()
+	deadClient := NewClient(opts)
+	client := NewClient(opts)
+	q := Queue{Name: "q", MaxAttempts: 2}
+
+	job, _ := deadClient.Submit(q, 100, nil)
+	if err := deadClient.heartbeat(q); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deadClient.wait(q); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a worker that stopped sending heartbeats a long time ago
+	conn := client.getConn()
+	conn.ZAdd(client.workersKey(q.Name), 0, deadClient.workerID)
+	client.putConn(conn)
+
+	if err := client.heartbeat(q); err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ := client.Job(job.ID)
+	if j.State != Queued || j.NumAttempts != 1 {
+		t.Errorf("job was not retried: %s (attempts: %d)", j.State, j.NumAttempts)
+	}
+
+	conn = client.getConn()
+	client.promoteJobs(q, conn)
+	client.putConn(conn)
+
+	j, err := client.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != job.ID {
+		t.Errorf("id mismatch: %d != %d", j.ID, job.ID)
+	}
+}
+
+func TestHeartbeat_DeadWorkerJobStates(t *testing.T) {
+	opts := optionsWithMock()
+	deadClient := NewClient(opts)
+	client := NewClient(opts)
+	q := Queue{Name: "q", MaxAttempts: 1}
+
+	finished, _ := deadClient.Submit(q, 100, nil)
+	working, _ := deadClient.Submit(q, 100, nil)
+	deadClient.heartbeat(q)
+	for i := 0; i < 2; i++ {
+		if _, err := deadClient.wait(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate a job that finished after its worker was last seen, whose
+	// in-flight entry was left behind
+	conn := client.getConn()
+	conn.HSetAll(client.jobKey(finished.ID), map[string]string{"state": strconv.Itoa(Finished)})
+	conn.ZAdd(client.workersKey(q.Name), 0, deadClient.workerID)
+	client.putConn(conn)
+
+	if err := client.heartbeat(q); err != nil {
+		t.Fatal(err)
+	}
+
+	if j, _ := client.Job(finished.ID); j.State != Finished {
+		t.Error("finished job was requeued:", j.State)
+	}
+
+	if j, _ := client.Job(working.ID); j.State != Dead || j.LastError != ErrWorkerLost.Error() {
+		t.Errorf("job should have exhausted its attempts: %s (%s)", j.State, j.LastError)
+	}
+
+	conn = client.getConn()
+	client.promoteJobs(q, conn)
+	client.putConn(conn)
+
+	if _, err := client.wait(q); err == nil {
+		t.Error("no job should have been queued")
+	}
+}
+
+func TestHeartbeat_DeadWorkerClaimedJob(t *testing.T) {
+	opts := optionsWithMock()
+	deadClient := NewClient(opts)
+	client := NewClient(opts)
+	q := Queue{Name: "q", MaxAttempts: 1}
+
+	job, _ := deadClient.Submit(q, 100, nil)
+
+	// Claim the job without marking it Working, as a worker does before
+	// persisting the job
+	conn := client.getConn()
+	deadClient.popJob(conn, client.wakeKey(q.Name), client.processingKey(q.Name, deadClient.workerID), time.Now(), client.priorityQueueKey(q.Name, 100))
+	conn.ZAdd(client.workersKey(q.Name), 0, deadClient.workerID)
+	client.putConn(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := client.EventsContext(ctx, EventFilter{Types: []EventType{EventQueued}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.heartbeat(q); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := nextEvent(t, events); e.JobID != job.ID || e.Error != ErrWorkerLost.Error() {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	j, err := client.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != job.ID || j.NumAttempts != 1 {
+		t.Errorf("job was not requeued: %d (attempts: %d)", j.ID, j.NumAttempts)
+	}
+}
+
+func TestHeartbeat_ExpiredLease(t *testing.T) {
+	opts := optionsWithMock()
+	hungClient := NewClient(opts)
+	client := NewClient(opts)
+	q := Queue{
+		Name:              "q",
+		MaxAttempts:       2,
+		VisibilityTimeout: 1 * time.Millisecond,
+	}
+
+	job, _ := hungClient.Submit(q, 100, nil)
+	hungClient.heartbeat(q)
+	j, err := hungClient.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.LeaseExpiry.IsZero() {
+		t.Error("lease expiry was not set")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := client.heartbeat(q); err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ = client.Job(job.ID)
+	if j.State != Queued || j.NumAttempts != 1 {
+		t.Errorf("job was not retried: %s (attempts: %d)", j.State, j.NumAttempts)
+	}
+
+	if ok, _ := hungClient.renewLease(&j, q); ok {
+		t.Error("lease should have been lost")
+	}
+}
+
+func TestFinish_LeaseExpired(t *testing.T) {
+	opts := optionsWithMock()
+	hungClient := NewClient(opts)
+	client := NewClient(opts)
+	q := Queue{
+		Name:              "q",
+		MaxAttempts:       2,
+		VisibilityTimeout: 1 * time.Millisecond,
+	}
+
+	batch, _, err := hungClient.SubmitBatch(q, []SubmitRequest{{Priority: 100}}, BatchCallback{Queue: Queue{Name: "done"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hungClient.heartbeat(q)
+	hung, err := hungClient.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := client.heartbeat(q); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := client.getConn()
+	client.promoteJobs(q, conn)
+	client.putConn(conn)
+
+	j, err := client.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.finish(&j, q); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hungClient.finish(&hung, q); err != ErrLeaseExpired {
+		t.Error("finish should have been rejected:", err)
+	}
+	if err := hungClient.fail(&hung, q, errors.New("connection refused")); err != ErrLeaseExpired {
+		t.Error("retry should have been rejected:", err)
+	}
+
+	j, _ = client.Job(hung.ID)
+	if j.State != Finished || j.NumAttempts != 2 {
+		t.Errorf("job was overwritten: %s (attempts: %d)", j.State, j.NumAttempts)
+	}
+
+	expected := Batch{ID: batch.ID, CallbackID: batch.CallbackID, Total: 1, Succeeded: 1}
+	if b, _ := client.Batch(batch.ID); b != expected {
+		t.Errorf("batch mismatch: %+v != %+v", b, expected)
+	}
+}
+
+func TestRegisterContext(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	ctx := WithMetadata(context.Background(), Metadata{"trace_id": "abc"})
+	client.SubmitContext(ctx, q, 100, nil)
+
+	next := make(chan context.Context)
+	client.RegisterContext(q, func(ctx context.Context, job *Job) error {
+		next <- ctx
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	canceller := client.Work()
+
+	var handlerCtx context.Context
+	select {
+	case handlerCtx = <-next:
+	case <-time.After(1 * time.Second):
+		t.Fatal("worker was not called")
+	}
+
+	if md := MetadataFromContext(handlerCtx); md["trace_id"] != "abc" {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+
+	canceller.Cancel()
+
+	select {
+	case <-handlerCtx.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+}
+
+func TestWorkContext(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+	job, _ := client.Submit(q, 100, nil)
+
+	next := make(chan struct{})
+	block := make(chan struct{})
+	defer close(block)
+	client.Register(q, func(job *Job) error {
+		next <- struct{}{}
+		<-block
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.WorkContext(ctx)
+	<-next
+	cancel()
+
+	for i := 0; i < 100; i++ {
+		j, _ := client.Job(job.ID)
+		if j.State == Dead {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("job was not failed after the context was done")
+}
+
+func TestAttempts(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 2}
+
+	job, _ := client.Submit(q, 100, nil)
+
+	j, _ := client.wait(q)
+	client.fail(&j, q, errors.New("connection refused"))
+
+	j, _ = client.Job(job.ID)
+	if j.LastError != "connection refused" {
+		t.Errorf("unexpected last error: %s", j.LastError)
+	}
+
+	// Bypass the retry delay
+	conn := client.getConn()
+	conn.ZAdd(client.delayedQueueKey(q.Name), 0, client.jobKey(job.ID))
+	client.promoteJobs(q, conn)
+	client.putConn(conn)
+
+	j, _ = client.wait(q)
+	client.finish(&j, q)
+
+	j, _ = client.Job(job.ID)
+	if len(j.Attempts) != 2 {
+		t.Fatalf("unexpected number of attempts: %d", len(j.Attempts))
+	}
+
+	for i, attempt := range j.Attempts {
+		if attempt.StartTime.IsZero() || attempt.EndTime.IsZero() {
+			t.Errorf("attempt %d: times were not recorded", i)
+		}
+		if attempt.WorkerID != client.workerID {
+			t.Errorf("attempt %d: unexpected worker: %s", i, attempt.WorkerID)
+		}
+	}
+
+	if j.Attempts[0].Error != "connection refused" || j.Attempts[1].Error != "" {
+		t.Errorf("unexpected errors: %q, %q", j.Attempts[0].Error, j.Attempts[1].Error)
+	}
+}
+
+func TestJob_NotFound(t *testing.T) {
+	client := newTestClient()
+
+	if _, err := client.Job(1); err != ErrJobNotFound {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestJob_Timestamps(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.SubmitDelayed(q, 1500*time.Millisecond, nil)
+
+	j, err := client.Job(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.DelayedUntil.Equal(job.DelayedUntil) || !j.CreationTime.Equal(job.CreationTime) {
+		t.Errorf("timestamps mismatch: %s != %s, %s != %s", j.DelayedUntil, job.DelayedUntil, j.CreationTime, job.CreationTime)
+	}
+
+	// Timestamps of earlier versions were whole seconds
+	conn := client.getConn()
+	conn.HSetAll(client.jobKey(job.ID), map[string]string{"delayed_until": "1500000000"})
+	client.putConn(conn)
+
+	j, err = client.Job(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.DelayedUntil.Equal(time.Unix(1500000000, 0)) {
+		t.Error("unexpected delayed until:", j.DelayedUntil)
+	}
+}
+
+func TestJob_StartTime(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil)
+	client.wait(q)
+
+	j, _ := client.Job(job.ID)
+	if j.StartTime.IsZero() || !j.StartTime.Equal(j.Attempts[0].StartTime.Truncate(time.Millisecond)) {
+		t.Errorf("unexpected start time: %s", j.StartTime)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	queued, _ := client.Submit(q, 100, nil)
+	delayed, _ := client.SubmitDelayed(q, 0, nil)
+
+	for _, job := range []Job{queued, delayed} {
+		job, err := client.CancelJob(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		j, _ := client.Job(job.ID)
+		if j.State != Canceled {
+			t.Error("job was not canceled:", j.State)
+		}
+	}
+
+	if j, err := client.wait(q); err == nil {
+		t.Errorf("canceled job was claimed: %d", j.ID)
+	}
+
+	job, _ := client.Submit(q, 100, nil)
+	client.wait(q)
+	if _, err := client.CancelJob(job.ID); err == nil {
+		t.Error("working job should not be canceled")
+	}
+}
+
+func TestCancelJob_Claimed(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil)
+
+	// Claim the job without marking it Working, as a worker does before
+	// persisting the job
+	conn := client.getConn()
+	client.popJob(conn, client.wakeKey(q.Name), client.processingKey(q.Name, client.workerID), time.Now(), client.priorityQueueKey(q.Name, 100))
+	client.putConn(conn)
+
+	if _, err := client.CancelJob(job.ID); err == nil {
+		t.Error("claimed job should not be canceled")
+	}
+}
+
+func TestWait_SkipsCanceled(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil)
+	client.CancelJob(job.ID)
+
+	// A stale key left on the queue must not be run
+	conn := client.getConn()
+	conn.RPush(client.priorityQueueKey(q.Name, 100), client.jobKey(job.ID))
+	client.putConn(conn)
+
+	if _, err := client.wait(q); err == nil {
+		t.Error("canceled job was claimed")
+	}
+
+	if j, _ := client.Job(job.ID); j.State != Canceled {
+		t.Error("job is no longer canceled:", j.State)
+	}
+}
+
+func TestCancelRunning(t *testing.T) {
+	opts := optionsWithMock()
+	client := NewClient(opts)
+	admin := NewClient(opts)
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil)
+
+	started := make(chan struct{})
+	done := make(chan error)
+	client.RegisterContext(q, func(ctx context.Context, job *Job) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		done <- ctx.Err()
+		return ctx.Err()
+	})
+
+	canceller := client.Work()
+	defer canceller.Cancel()
+	<-started
+
+	if err := admin.CancelRunning(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+
+	j, _ := admin.Job(job.ID)
+	if j.State != Canceled {
+		t.Error("job was not canceled:", j.State)
+	}
+}
+
+// flakyCancelConn fails to subscribe to a cancel channel once, as when the
+// connection is lost.
+type flakyCancelConn struct {
+	Conn
+	failed int32
+}
+
+func (c *flakyCancelConn) Subscribe(channel string) (<-chan string, func() error, error) {
+	if strings.Contains(channel, ":cancel:") && atomic.CompareAndSwapInt32(&c.failed, 0, 1) {
+		return nil, nil, errors.New("connection refused")
+	}
+
+	return c.Conn.Subscribe(channel)
+}
+
+func TestCancelRunning_SubscribeRetried(t *testing.T) {
+	opts := optionsWithMock()
+	admin := NewClient(opts)
+	conn := &flakyCancelConn{Conn: opts.ConnFactory()}
+	client := NewClient(&Options{ConnFactory: func() Conn { return conn }})
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil)
+
+	started := make(chan struct{})
+	client.RegisterContext(q, func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	canceller := client.Work()
+	defer canceller.Cancel()
+	<-started
+
+	err := admin.CancelRunning(job.ID)
+	for start := time.Now(); err != nil && time.Since(start) < 3*subscribeRetryInterval; time.Sleep(10 * time.Millisecond) {
+		err = admin.CancelRunning(job.ID)
+	}
+	if err != nil {
+		t.Fatal("subscription was not retried:", err)
+	}
+}
+
+func TestMoveJob(t *testing.T) {
+	client := newTestClient()
+	fast := Queue{Name: "fast"}
+	slow := Queue{Name: "slow"}
+
+	job, _ := client.Submit(fast, 100, nil)
+
+	if _, err := client.MoveJob(job.ID, slow, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.wait(fast); err == nil {
+		t.Error("job was not removed from its queue")
+	}
+
+	j, err := client.wait(slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != job.ID || j.Queue != slow.Name || j.Priority != 50 {
+		t.Errorf("unexpected job: %d in %q with priority %d", j.ID, j.Queue, j.Priority)
+	}
+
+	if _, err := client.MoveJob(job.ID, fast, 100); err == nil {
+		t.Error("working job should not be moved")
+	}
+}
+
+func TestMoveJob_Dead(t *testing.T) {
+	client := newTestClient()
+	fast := Queue{Name: "fast", MaxAttempts: 1}
+	slow := Queue{Name: "slow"}
+
+	job, _ := client.Submit(fast, 100, nil)
+	j, _ := client.wait(fast)
+	client.fail(&j, fast, errors.New("connection refused"))
+
+	if _, err := client.MoveJob(job.ID, slow, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := client.wait(slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != job.ID || j.NumAttempts != 1 {
+		t.Errorf("unexpected job: %d with %d attempts", j.ID, j.NumAttempts)
+	}
+}
+
+func TestMoveJob_DeadUniqueKey(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 1}
+
+	job, _ := client.Submit(q, 100, nil, WithUniqueKey("report", time.Hour))
+	j, _ := client.wait(q)
+	client.fail(&j, q, errors.New("connection refused"))
+
+	if _, err := client.MoveJob(job.ID, q, 100); err == nil {
+		t.Error("dead job with a unique key should not be moved")
+	}
+}
+
+func TestSetPriority(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	client.Submit(q, 50, nil)
+	job, _ := client.Submit(q, 10, nil)
+
+	if _, err := client.SetPriority(job.ID, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := client.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != job.ID || j.Priority != 100 {
+		t.Errorf("unexpected job: %d with priority %d", j.ID, j.Priority)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil)
+
+	at := time.Now().Add(time.Hour)
+	if _, err := client.Reschedule(job.ID, at); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.wait(q); err == nil {
+		t.Error("rescheduled job should not be processed")
+	}
+
+	j, _ := client.Job(job.ID)
+	if !j.DelayedUntil.Equal(at.Truncate(time.Millisecond)) {
+		t.Errorf("delayed until mismatch: %s != %s", j.DelayedUntil, at)
+	}
+
+	if _, err := client.Reschedule(job.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := client.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != job.ID {
+		t.Errorf("id mismatch: %d != %d", j.ID, job.ID)
+	}
+}
+
+func TestSubmit_UniqueKey(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, err := client.Submit(q, 100, "charge", WithUniqueKey("charge-42", time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dup, err := client.SubmitDelayed(q, time.Minute, "charge", WithUniqueKey("charge-42", time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup.ID != job.ID || dup.State != Queued {
+		t.Errorf("unexpected job: %d in state %s", dup.ID, dup.State)
+	}
+
+	j, _ := client.wait(q)
+	if _, err := client.wait(q); err == nil {
+		t.Error("duplicate job was queued")
+	}
+
+	client.finish(&j, q)
+
+	// The key is held for the TTL after the job finishes
+	dup, _ = client.Submit(q, 100, "charge", WithUniqueKey("charge-42", time.Hour))
+	if dup.ID != job.ID || dup.State != Finished {
+		t.Errorf("unexpected job: %d in state %s", dup.ID, dup.State)
+	}
+}
+
+func TestSubmit_UniqueKeyReleased(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil, WithUniqueKey("report", 0))
+	if _, err := client.CancelJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	next, _ := client.Submit(q, 100, nil, WithUniqueKey("report", 0))
+	if next.ID == job.ID {
+		t.Error("unique key was not released after the job was canceled")
+	}
+}
+
+func TestSubmit_Debounce(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, 1, WithDebounce("reindex:1", time.Hour))
+	for i := 2; i <= 3; i++ {
+		dup, err := client.Submit(q, 100, i, WithDebounce("reindex:1", time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup.ID != job.ID {
+			t.Errorf("id mismatch: %d != %d", dup.ID, job.ID)
+		}
+	}
+
+	j, _ := client.Job(job.ID)
+	var payload int
+	j.UnmarshalPayload(&payload)
+	if payload != 3 {
+		t.Errorf("payload was not replaced: %d", payload)
+	}
+	if j.DelayedUntil.Before(job.DelayedUntil) || j.DelayedUntil.Before(time.Now().Add(59*time.Minute)) {
+		t.Error("job was not delayed until the end of the window:", j.DelayedUntil)
+	}
+
+	// Once the job is no longer delayed, a new job is submitted
+	client.Reschedule(job.ID, time.Now())
+	next, _ := client.Submit(q, 100, 4, WithDebounce("reindex:1", time.Hour))
+	if next.ID == job.ID {
+		t.Error("job was debounced after it was no longer delayed")
+	}
+}
+
+func TestSubmit_Throttle(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	job, _ := client.Submit(q, 100, nil, WithThrottle("digest", 50*time.Millisecond))
+	dup, _ := client.Submit(q, 100, nil, WithThrottle("digest", 50*time.Millisecond))
+	if dup.ID != job.ID {
+		t.Errorf("id mismatch: %d != %d", dup.ID, job.ID)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	next, _ := client.Submit(q, 100, nil, WithThrottle("digest", 50*time.Millisecond))
+	if next.ID == job.ID {
+		t.Error("job was throttled after the window")
+	}
+}
+
+func TestSubmitMany(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	first, _ := client.Submit(q, 0, nil)
+	jobs, err := client.SubmitMany(q, []SubmitRequest{
+		{Priority: 10, Payload: 1},
+		{Priority: 50, Payload: 2},
+		{Payload: 3, At: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 3 {
+		t.Fatalf("expected 3 jobs, got %d", len(jobs))
+	}
+	for i, job := range jobs {
+		if job.ID != first.ID+i+1 {
+			t.Errorf("id mismatch: %d != %d", job.ID, first.ID+i+1)
+		}
+
+		j, err := client.Job(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var payload int
+		j.UnmarshalPayload(&payload)
+		if payload != i+1 || j.State != Queued || j.Priority != job.Priority {
+			t.Errorf("unexpected job: %+v", j)
+		}
+	}
+
+	for _, id := range []int{jobs[1].ID, jobs[0].ID, first.ID} {
+		j, err := client.wait(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.ID != id {
+			t.Errorf("id mismatch: %d != %d", j.ID, id)
+		}
+	}
+
+	if _, err := client.wait(q); err == nil {
+		t.Error("delayed job should not be processed before its time")
+	}
+}
+
+func TestSubmitMany_Unique(t *testing.T) {
+	client := newTestClient()
+
+	_, err := client.SubmitMany(Queue{Name: "q"}, []SubmitRequest{
+		{Options: []SubmitOption{WithUniqueKey("report", 0)}},
+	})
+	if err == nil {
+		t.Error("expected unique job to be rejected")
+	}
+}
+
+func TestSubmitMany_TooMany(t *testing.T) {
+	client := newTestClient()
+
+	reqs := make([]SubmitRequest, MaxSubmitRequests+1)
+	if _, err := client.SubmitMany(Queue{Name: "q"}, reqs); err == nil {
+		t.Error("expected too many requests to be rejected")
+	}
+}
+
+func TestSubmit_OnSuccess(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+	next := Queue{Name: "next"}
+
+	ctx := WithMetadata(context.Background(), Metadata{"request_id": "abc"})
+	client.SubmitContext(ctx, q, 100, nil,
+		WithOnSuccess(next, 10, "thumbnail"),
+		WithOnSuccess(next, 50, map[string]int64{"id": 1 << 60}),
+		WithOnDeath(next, 100, "cleanup"))
+
+	j, _ := client.wait(q)
+	if err := client.finish(&j, q); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload map[string]int64
+	f, _ := client.wait(next)
+	f.UnmarshalPayload(&payload)
+	if payload["id"] != 1<<60 || f.Metadata["request_id"] != "abc" {
+		t.Errorf("unexpected follow-up job: %+v", f)
+	}
+
+	var s string
+	f, _ = client.wait(next)
+	f.UnmarshalPayload(&s)
+	if s != "thumbnail" {
+		t.Errorf("payload mismatch: %q != %q", s, "thumbnail")
+	}
+
+	if _, err := client.wait(next); err == nil {
+		t.Error("death follow-up job was submitted")
+	}
+}
+
+func TestSubmit_OnDeath(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 1}
+	next := Queue{Name: "next"}
+
+	client.Submit(q, 100, nil, WithOnSuccess(next, 100, "notify"), WithOnDeath(next, 100, "cleanup"))
+
+	j, _ := client.wait(q)
+	client.fail(&j, q, errors.New("connection refused"))
+
+	var s string
+	f, _ := client.wait(next)
+	f.UnmarshalPayload(&s)
+	if s != "cleanup" {
+		t.Errorf("payload mismatch: %q != %q", s, "cleanup")
+	}
+
+	if _, err := client.wait(next); err == nil {
+		t.Error("success follow-up job was submitted")
+	}
+}
+
+func TestSubmit_ThrottleWindowTooShort(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	for _, d := range []time.Duration{0, 500 * time.Microsecond} {
+		if _, err := client.Submit(q, 100, nil, WithThrottle("digest", d)); err == nil {
+			t.Errorf("expected an error for a throttle window of %s", d)
+		}
+		if _, err := client.Submit(q, 100, nil, WithDebounce("reindex", d)); err == nil {
+			t.Errorf("expected an error for a debounce window of %s", d)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package koda
+
+import "strconv"
+
+// completion guards the completion of a job. See Conn.CompleteJob.
+type completion struct {
+	// If set, the job must be in one of these states
+	States []string
+
+	// If set, the job must be removed from one of these
+	FromList string
+	FromZSet string
+}
+
+// completeJob atomically persists the given fields of a job that has
+// finished, died or been canceled, submits its follow-up jobs, which carry
+// the job's Metadata, and counts the job towards its batch as counter, which
+// is one of "succeeded", "dead" or "canceled". Any states or lists set on cmp
+// guard the write. ok will be false if the guard failed, and nothing was
+// written.
+func (c *Client) completeJob(j *Job, conn Conn, cmp completion, followUps []followUp, counter string, fields ...string) (ok bool, err error) {
+	hash, err := jobFields(j, fields...)
+	if err != nil {
+		return false, err
+	}
+
+	jobs := make([]Job, len(followUps))
+	for i, f := range followUps {
+		jobs[i] = Job{
+			payload:  f.Payload,
+			Queue:    f.Queue,
+			Priority: f.Priority,
+			State:    Queued,
+			Metadata: j.Metadata,
+		}
+	}
+
+	hashes, err := c.allocateJobs(conn, jobs)
+	if err != nil {
+		return false, err
+	}
+
+	newJobs := make([]FollowUpJob, len(jobs))
+	for i := range jobs {
+		newJobs[i] = FollowUpJob{
+			Key:     c.jobKey(jobs[i].ID),
+			Fields:  hashes[i],
+			ListKey: c.priorityQueueKey(jobs[i].Queue, jobs[i].Priority),
+			WakeKey: c.wakeKey(jobs[i].Queue),
+		}
+	}
+
+	var batchKey string
+	if j.BatchID != 0 {
+		batchKey = c.batchKey(j.BatchID)
+	}
+
+	ok, err = conn.CompleteJob(
+		c.jobKey(j.ID),
+		cmp.States,
+		cmp.FromList,
+		cmp.FromZSet,
+		hash,
+		newJobs,
+		batchKey,
+		counter,
+		strconv.Itoa(Queued))
+
+	if err != nil || !ok {
+		return false, err
+	}
+
+	for i := range jobs {
+		c.publishEvent(conn, EventCreated, &jobs[i], nil)
+		c.publishEvent(conn, EventQueued, &jobs[i], nil)
+	}
+
+	return true, nil
+}
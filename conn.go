@@ -6,14 +6,116 @@ import "time"
 // Note to implementers, each function must be atomic.
 type Conn interface {
 	Incr(key string) (int, error)
+	// IncrBy increments key by n, and returns its new value
+	IncrBy(key string, n int) (int, error)
 	// TODO: Update this to return a map[string]string
 	HGetAll(key string) ([]string, error)
 	HSetAll(key string, fields map[string]string) error
 	RPush(key string, value ...string) (int, error)
-	BLPop(timeout time.Duration, keys ...string) ([]string, error)
-	ZAddNX(key string, score float64, member string) (int, error)
-	// ZPopByScore has the same interface as ZRANGEBYSCORE, but also removes each member
-	ZPopByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) ([]string, error)
-	Subscribe(channel string) (<-chan string, error)
+	// ClaimJob pops the first value of the first non-empty list of keys, and
+	// adds it to the sorted set dest with the given score. If every list is
+	// empty, it blocks for up to timeout until a value is pushed onto the list
+	// wakeKey, then tries again. Values must be pushed onto wakeKey in the
+	// same operation as onto keys, and wakeKey is trimmed to hold no more
+	// values than remain on keys. Returns the list's key and the value, as
+	// BLPOP does, or nothing if no value was claimed.
+	ClaimJob(timeout time.Duration, wakeKey, dest string, score float64, keys ...string) ([]string, error)
+	ZAdd(key string, score float64, member string) (int, error)
+	// ZAddXX only updates the scores of existing members, and returns the number
+	// of members updated
+	ZAddXX(key string, score float64, member string) (int, error)
+	ZRem(key string, members ...string) (int, error)
+	ZRangeByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) ([]string, error)
+	// ZPopByScoreZAdd removes the members of the sorted set key selected as by
+	// ZRANGEBYSCORE, and adds each to the sorted set dest with the given score
+	ZPopByScoreZAdd(key string, min, max float64, minIncl, maxIncl bool, offset, count int, dest string, score float64) ([]string, error)
+	// SubmitJob sets fields on the hash keyPrefix+id, then pushes the hash's key
+	// onto the tail of listKey and wakeKey (if set), adds it to the sorted set
+	// delayedKey with the given score unless already a member (if set). If
+	// idKey is set, a new id is allocated by incrementing idKey, and is stored
+	// in the hash's "id" field. The job's id is returned.
+	SubmitJob(idKey string, id int, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) (int, error)
+	// SubmitUniqueJob has the same interface as SubmitJob, with a new id
+	// allocated by incrementing idKey, but first checks uniqueKey. If
+	// uniqueKey exists, nothing is changed, and the id it holds is returned
+	// with false. Otherwise uniqueKey is set to the new job's id, expiring
+	// after ttl if it is positive.
+	SubmitUniqueJob(uniqueKey string, ttl time.Duration, idKey, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) (int, bool, error)
+	// DebounceJob checks whether debounceKey holds the id of a job that is a
+	// member of the sorted set delayedKey. If so, the updateFields of fields
+	// are set on the job's hash, its score is updated, and its id is returned
+	// with false. Otherwise a job is submitted as by SubmitUniqueJob. In
+	// either case, debounceKey expires after ttl.
+	DebounceJob(debounceKey string, ttl time.Duration, idKey, keyPrefix string, fields map[string]string, updateFields []string, delayedKey string, score float64) (int, bool, error)
+	// MoveJob checks that the hash field "state" of jobKey is one of states,
+	// then removes jobKey from the list fromList and the sorted set fromZSet
+	// (if set), sets fields on the hash, then pushes jobKey onto the tail of
+	// toList and wakeKey, and adds it to the sorted set toZSet with the given
+	// score (if set). If onlyIfRemoved is true, and jobKey was not removed from
+	// fromList or fromZSet, the hash and toList and toZSet are left unchanged.
+	// Returns false if the state did not match, or jobKey was not removed.
+	MoveJob(jobKey string, states []string, fromList, fromZSet, toList, wakeKey, toZSet string, score float64, fields map[string]string, onlyIfRemoved bool) (bool, error)
+	// CompleteJob checks that the hash field "state" of jobKey is one of
+	// states, and removes jobKey from the list fromList and the sorted set
+	// fromZSet (each if set). If the state did not match, or jobKey was not
+	// removed from either, nothing is changed and false is returned.
+	// Otherwise fields are set on the hash, and each of jobs has its Fields
+	// set on the hash Key, which is pushed onto the tail of ListKey and
+	// WakeKey. If batchKey is set, its hash field counter is incremented and
+	// its field "pending" decremented. Once "pending" reaches zero, the job
+	// hash named by the batch's "callback_key" field has its "payload" field
+	// set to the batch's integer fields, encoded as a JSON object, and its
+	// "state" field set to callbackState, and is pushed onto the tail of the
+	// lists named by the batch's "callback_list" and "callback_wake" fields.
+	CompleteJob(jobKey string, states []string, fromList, fromZSet string, fields map[string]string, jobs []FollowUpJob, batchKey, counter, callbackState string) (bool, error)
+	// PromoteJobs removes up to count job keys from the sorted set key with a
+	// score no greater than max, and pushes each onto the tail of the list
+	// listKeyPrefix+p, where p is the job hash's "priority" field, and of the
+	// list wakeKey. Returns the lowest score remaining in key, or +Inf if key
+	// is empty.
+	PromoteJobs(key string, max float64, listKeyPrefix, wakeKey string, count int) (float64, error)
+	// SetNX sets key to value, expiring after ttl, if key does not exist.
+	// Returns whether key was set.
+	SetNX(key, value string, ttl time.Duration) (bool, error)
+	// AcquireLock sets key to value, expiring after ttl, if key does not exist
+	// or is already set to value. Returns whether key was set.
+	AcquireLock(key, value string, ttl time.Duration) (bool, error)
+	// ExpireIfEqual expires key after ttl if it is set to value. If ttl is not
+	// positive, key is deleted. Returns whether key was set to value.
+	ExpireIfEqual(key, value string, ttl time.Duration) (bool, error)
+	Publish(channel string, message string) (int, error)
+	// Pipeline returns a Pipeline, on which commands are queued until Exec is
+	// called.
+	Pipeline() Pipeline
+	// Subscribe delivers each message published to channel on the returned
+	// channel, until the returned function is called, which closes it.
+	Subscribe(channel string) (<-chan string, func() error, error)
+	// PSubscribe has the same interface as Subscribe, but subscribes to every
+	// channel matching the glob-style pattern. Each message is delivered as
+	// the channel it was published to, followed by the message.
+	PSubscribe(pattern string) (<-chan []string, func() error, error)
 	Close() error
 }
+
+// FollowUpJob is a job submitted by Conn.CompleteJob. It is an alias of an
+// unnamed type, so that it can be implemented without importing koda.
+type FollowUpJob = struct {
+	Key     string
+	Fields  map[string]string
+	ListKey string
+	WakeKey string
+}
+
+// Pipeline queues commands, which are sent together and executed atomically
+// when Exec is called. Exec may be called more than once, each time executing
+// the commands queued since the last call.
+//
+// Pipeline is an alias, so that Conn may be implemented without importing
+// this package.
+type Pipeline = interface {
+	HSetAll(key string, fields map[string]string)
+	RPush(key string, value ...string)
+	ZAdd(key string, score float64, member string)
+	Publish(channel string, message string)
+	Exec() error
+}
@@ -0,0 +1,70 @@
+package koda
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjlucas/koda-go/internal/mock"
+)
+
+func receive(t *testing.T, ch <-chan string) string {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return ""
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var conn Conn = mock.NewConn()
+
+	ch, unsubscribe, err := conn.Subscribe("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if n, err := conn.Publish("foo", msg); err != nil || n != 1 {
+			t.Fatalf("Publish() = %d, %v, want 1, nil", n, err)
+		}
+		if got := receive(t, ch); got != msg {
+			t.Errorf("got %q, want %q", got, msg)
+		}
+	}
+
+	if err := unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after unsubscribing")
+	}
+
+	if n, _ := conn.Publish("foo", "d"); n != 0 {
+		t.Errorf("Publish() = %d, want 0", n)
+	}
+}
+
+func TestPSubscribe(t *testing.T) {
+	var conn Conn = mock.NewConn()
+
+	ch, unsubscribe, err := conn.PSubscribe("foo:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	conn.Publish("bar:1", "a")
+	conn.Publish("foo:1", "b")
+
+	select {
+	case msg := <-ch:
+		if msg[0] != "foo:1" || msg[1] != "b" {
+			t.Errorf("got %v, want [foo:1 b]", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
@@ -0,0 +1,21 @@
+package koda
+
+import "context"
+
+// Metadata is request-scoped data, such as a trace ID, that is carried from
+// the submitter of a job to its handler.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx with md attached. Jobs submitted using
+// the returned context will carry md.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the Metadata attached to ctx, if any.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
@@ -0,0 +1,266 @@
+package koda
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule determines the occurrences of a periodic job.
+type schedule interface {
+	// Next returns the first occurrence after t, or the zero time if there is
+	// none.
+	Next(t time.Time) time.Time
+}
+
+// everySchedule occurs at every multiple of an interval.
+type everySchedule struct {
+	Interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.Interval).Add(s.Interval)
+}
+
+// cronSchedule occurs at every second matching each of its fields, in its
+// location. Each field is a bit set of the matching values.
+type cronSchedule struct {
+	Second, Minute, Hour, Dom, Month, Dow uint64
+
+	// Whether the day of month or day of week fields were unrestricted
+	DomStar, DowStar bool
+
+	Location *time.Location
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dowNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseSchedule parses a cron expression with 5 fields (minute, hour, day of
+// month, month, day of week) or 6 fields (with a leading second), a
+// descriptor such as @daily, or an interval such as "@every 1h30m". The
+// expression may be prefixed with "CRON_TZ=<zone> " or "TZ=<zone> " to
+// evaluate it in a time zone other than the local one.
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	loc := time.Local
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexByte(spec, ' ')
+		if i < 0 {
+			return nil, errors.New("missing schedule after time zone")
+		}
+
+		var err error
+		loc, err = time.LoadLocation(spec[strings.IndexByte(spec, '=')+1 : i])
+		if err != nil {
+			return nil, err
+		}
+
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, err
+		}
+
+		if d < time.Second {
+			return nil, fmt.Errorf("interval must be at least 1s: %s", d)
+		}
+
+		return everySchedule{Interval: d}, nil
+	}
+
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields, found %d: %s", len(fields), spec)
+	}
+
+	s := &cronSchedule{
+		Location: loc,
+		DomStar:  strings.HasPrefix(fields[3], "*") || fields[3] == "?",
+		DowStar:  strings.HasPrefix(fields[5], "*") || fields[5] == "?",
+	}
+
+	var err error
+	parse := func(field string, min, max int, names map[string]int) uint64 {
+		if err != nil {
+			return 0
+		}
+
+		var bits uint64
+		bits, err = parseCronField(field, min, max, names)
+		return bits
+	}
+
+	s.Second = parse(fields[0], 0, 59, nil)
+	s.Minute = parse(fields[1], 0, 59, nil)
+	s.Hour = parse(fields[2], 0, 23, nil)
+	s.Dom = parse(fields[3], 1, 31, nil)
+	s.Month = parse(fields[4], 1, 12, monthNames)
+	s.Dow = parse(fields[5], 0, 7, dowNames)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both 0 and 7 are Sunday
+	if s.Dow&(1<<7) != 0 {
+		s.Dow = s.Dow&^(1<<7) | 1
+	}
+
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (a-b),
+// wildcards (* or ?), each optionally followed by a step (/n), into a bit set.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		expr, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			expr = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step: %s", part)
+			}
+		}
+
+		var start, end int
+		switch i := strings.IndexByte(expr, '-'); {
+		case expr == "*" || expr == "?":
+			start, end = min, max
+		case i >= 0:
+			var err error
+			if start, err = parseCronValue(expr[:i], names); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(expr[i+1:], names); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(expr, names); err != nil {
+				return 0, err
+			}
+
+			end = start
+			if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("out of range [%d, %d]: %s", min, max, part)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %s", s)
+	}
+
+	return v, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.Dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.Dow&(1<<uint(t.Weekday())) != 0
+
+	if s.DomStar || s.DowStar {
+		return domMatch && dowMatch
+	}
+
+	// As in cron, if both fields are restricted, either may match
+	return domMatch || dowMatch
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.In(s.Location).Truncate(time.Second).Add(time.Second)
+
+	// Give up on schedules that never occur, such as February 30th
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.Month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.Hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.Location)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.Minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.Second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t.In(loc)
+}
@@ -0,0 +1,72 @@
+package koda
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database unavailable")
+	}
+
+	from := time.Date(2020, 1, 31, 10, 30, 15, 0, time.UTC)
+	cases := []struct {
+		Spec string
+		Next time.Time
+	}{
+		{"CRON_TZ=UTC * * * * *", time.Date(2020, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC */15 * * * * *", time.Date(2020, 1, 31, 10, 30, 30, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 9 * * mon-fri", time.Date(2020, 2, 3, 9, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 0 29 feb *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 0 1,15 * 0", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 30 5 * * 7", time.Date(2020, 2, 2, 5, 30, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC @hourly", time.Date(2020, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC @monthly", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC @every 1h", time.Date(2020, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=America/New_York 0 9 * * *", time.Date(2020, 1, 31, 9, 0, 0, 0, ny)},
+		{"TZ=America/New_York 0 5 * * *", time.Date(2020, 2, 1, 5, 0, 0, 0, ny)},
+	}
+
+	for _, c := range cases {
+		s, err := parseSchedule(c.Spec)
+		if err != nil {
+			t.Errorf("%q: %s", c.Spec, err)
+			continue
+		}
+
+		if next := s.Next(from); !next.Equal(c.Next) {
+			t.Errorf("%q: got %s, want %s", c.Spec, next, c.Next)
+		}
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@every 10ms",
+		"@every soon",
+		"CRON_TZ=Nowhere/Special * * * * *",
+	}
+
+	for _, spec := range specs {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestSchedule_Never(t *testing.T) {
+	s, _ := parseSchedule("0 0 30 2 *")
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Error("unexpected occurrence:", next)
+	}
+}
@@ -1,6 +1,8 @@
 package koda
 
 import (
+	"context"
+	"runtime/debug"
 	"sync"
 	"time"
 )
@@ -12,14 +14,24 @@ type jobManager struct {
 	Queue    Queue
 	c        *Client
 	jobs     map[int]Job
+	cancels  map[int]context.CancelFunc
 	jobsLock sync.Mutex
 }
 
-func (m *jobManager) Add(job Job) {
+// Add begins managing a job. cancel is called once the job is no longer
+// managed, to cancel the context given to the job's handler.
+func (m *jobManager) Add(job Job, cancel context.CancelFunc) {
 	m.jobsLock.Lock()
 	defer m.jobsLock.Unlock()
 
 	m.jobs[job.ID] = job
+	m.cancels[job.ID] = cancel
+}
+
+func (m *jobManager) remove(id int) {
+	m.cancels[id]()
+	delete(m.jobs, id)
+	delete(m.cancels, id)
 }
 
 func (m *jobManager) Success(job Job) {
@@ -27,28 +39,68 @@ func (m *jobManager) Success(job Job) {
 	defer m.jobsLock.Unlock()
 
 	if j, ok := m.jobs[job.ID]; ok {
-		m.c.finish(&j)
-		delete(m.jobs, job.ID)
+		m.c.finish(&j, m.Queue)
+		m.remove(job.ID)
 	}
 }
 
-func (m *jobManager) fail(job Job) {
-	if j, ok := m.jobs[job.ID]; ok {
-		if job.NumAttempts < m.Queue.MaxAttempts {
-			m.c.retry(&j, m.Queue)
+// RenewLeases extends the lease of every in-flight job. Jobs whose lease has
+// been lost are no longer managed, as they now belong to another worker.
+func (m *jobManager) RenewLeases() {
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+
+	for id, j := range m.jobs {
+		ok, err := m.c.renewLease(&j, m.Queue)
+		if err != nil {
+			continue
+		}
+
+		if ok {
+			m.jobs[id] = j
 		} else {
-			m.c.kill(&j)
+			m.remove(id)
 		}
+	}
+}
 
-		delete(m.jobs, job.ID)
+func (m *jobManager) fail(job Job, err error) {
+	if j, ok := m.jobs[job.ID]; ok {
+		m.c.fail(&j, m.Queue, err)
+		m.remove(job.ID)
 	}
 }
 
-func (m *jobManager) Fail(job Job) {
+// Fail fails a job whose handler returned err
+func (m *jobManager) Fail(job Job, err error) {
 	m.jobsLock.Lock()
 	defer m.jobsLock.Unlock()
 
-	m.fail(job)
+	m.fail(job, err)
+}
+
+// Cancel places a job in the Canceled state, and cancels its handler's
+// context, if the job is managed.
+func (m *jobManager) Cancel(id int) {
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+
+	if j, ok := m.jobs[id]; ok {
+		m.c.cancel(&j, m.Queue)
+		m.remove(id)
+	}
+}
+
+// Timeout fails a job whose handler has exceeded its timeout.
+func (m *jobManager) Timeout(job Job) {
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+
+	if j, ok := m.jobs[job.ID]; ok {
+		j.TimedOut = true
+		m.jobs[job.ID] = j
+		m.fail(job, ErrTimeout)
+	}
 }
 
 func (m *jobManager) FailAllJobs() {
@@ -61,18 +113,19 @@ func (m *jobManager) FailAllJobs() {
 	}
 
 	for _, job := range jobs {
-		m.fail(job)
+		m.fail(job, ErrCancelled)
 	}
 }
 
 type dispatcher struct {
 	Queue   Queue
-	Handler HandlerFunc
+	Handler ContextHandlerFunc
 	client  *Client
 
-	cancel     chan struct{}
-	slots      chan struct{}
-	jobManager jobManager
+	cancel          chan struct{}
+	cancelHeartbeat chan struct{}
+	slots           chan struct{}
+	jobManager      jobManager
 }
 
 // Cancel all running jobs. If timeout is set, will block until
@@ -98,18 +151,53 @@ func (d *dispatcher) Cancel(timeout time.Duration) {
 	<-d.cancel
 
 	d.jobManager.FailAllJobs()
+
+	d.cancelHeartbeat <- struct{}{}
+	<-d.cancelHeartbeat
+	d.client.scheduler.Remove(d.Queue)
+	d.client.stopWorking(d.Queue)
+}
+
+// heartbeat periodically notifies other workers that this worker is alive,
+// renews the leases of in-flight jobs, and recovers jobs from workers that
+// are not alive.
+func (d *dispatcher) heartbeat() {
+	ticker := time.NewTicker(d.client.opts.WorkerTimeout / 3)
+	defer ticker.Stop()
+	leaseTicker := time.NewTicker(d.Queue.VisibilityTimeout / 3)
+	defer leaseTicker.Stop()
+
+	d.client.heartbeat(d.Queue)
+
+	for {
+		select {
+		case <-d.cancelHeartbeat:
+			close(d.cancelHeartbeat)
+			return
+		case <-ticker.C:
+			d.client.heartbeat(d.Queue)
+		case <-leaseTicker.C:
+			d.jobManager.RenewLeases()
+		}
+	}
 }
 
 func (d *dispatcher) Run() {
+	d.Queue = d.Queue.withDefaults()
 	d.slots = make(chan struct{}, d.Queue.NumWorkers)
 	for i := 0; i < d.Queue.NumWorkers; i++ {
 		d.slots <- struct{}{}
 	}
 
 	d.cancel = make(chan struct{})
+	d.cancelHeartbeat = make(chan struct{})
 	d.jobManager.Queue = d.Queue
 	d.jobManager.c = d.client
 	d.jobManager.jobs = make(map[int]Job)
+	d.jobManager.cancels = make(map[int]context.CancelFunc)
+
+	go d.heartbeat()
+	d.client.scheduler.Add(d.Queue)
 
 	go func() {
 		for {
@@ -124,15 +212,17 @@ func (d *dispatcher) Run() {
 					break
 				}
 
-				d.jobManager.Add(job)
+				ctx, cancel := context.WithCancel(context.Background())
+				if timeout := job.timeout(d.Queue); timeout > 0 {
+					ctx, cancel = context.WithTimeout(context.Background(), timeout)
+				}
+				if job.Metadata != nil {
+					ctx = WithMetadata(ctx, job.Metadata)
+				}
+				d.jobManager.Add(job, cancel)
 
 				go func() {
-					err := d.Handler(&job)
-					if err != nil {
-						d.jobManager.Fail(job)
-					} else {
-						d.jobManager.Success(job)
-					}
+					d.process(ctx, job)
 
 					// Don't put slot back into pool until job status has been updated
 					d.slots <- struct{}{}
@@ -141,3 +231,44 @@ func (d *dispatcher) Run() {
 		}
 	}()
 }
+
+// handle runs the handler for a job, recovering from any panic.
+func (d *dispatcher) handle(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicErr := &PanicError{Value: v, Stack: debug.Stack()}
+			if d.client.opts.PanicHandler != nil {
+				d.client.opts.PanicHandler(&job, panicErr)
+			}
+			err = panicErr
+		}
+	}()
+
+	return d.Handler(ctx, &job)
+}
+
+// process runs the handler for a job, and updates the job's status. If the
+// job times out, process returns without waiting for the handler to return.
+func (d *dispatcher) process(ctx context.Context, job Job) {
+	done := make(chan error, 1)
+	go func() {
+		done <- d.handle(ctx, job)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			err = <-done
+		}
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		d.jobManager.Timeout(job)
+	} else if err != nil {
+		d.jobManager.Fail(job, err)
+	} else {
+		d.jobManager.Success(job)
+	}
+}
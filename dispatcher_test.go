@@ -1,6 +1,7 @@
 package koda
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
@@ -33,7 +34,7 @@ func TestDispatcherRun(t *testing.T) {
 	dispatcher := dispatcher{
 		Queue:  q,
 		client: c,
-		Handler: func(job *Job) error {
+		Handler: func(ctx context.Context, job *Job) error {
 			hits <- struct{}{}
 			if len(hits) >= N {
 				next <- struct{}{}
@@ -103,7 +104,7 @@ func TestDispatcherRun_Retry(t *testing.T) {
 	dispatcher := dispatcher{
 		Queue:  q,
 		client: c,
-		Handler: func(job *Job) error {
+		Handler: func(ctx context.Context, job *Job) error {
 			hits++
 			if hits == n {
 				next <- struct{}{}
@@ -140,7 +141,7 @@ func TestDispatcher(t *testing.T) {
 	dispatcher := dispatcher{
 		Queue:  q,
 		client: c,
-		Handler: func(job *Job) error {
+		Handler: func(ctx context.Context, job *Job) error {
 			next <- struct{}{}
 			lock.Lock()
 			lock.Unlock()
@@ -173,7 +174,7 @@ func TestDispatcherCancel_Timeout(t *testing.T) {
 	dispatcher := dispatcher{
 		Queue:  q,
 		client: c,
-		Handler: func(job *Job) error {
+		Handler: func(ctx context.Context, job *Job) error {
 			next <- struct{}{}
 			next <- struct{}{}
 			return nil
@@ -204,3 +205,92 @@ func TestDispatcherCancel_Timeout(t *testing.T) {
 		t.Error("job was not marked as finished: ", j.State)
 	}
 }
+
+func TestDispatcherRun_Timeout(t *testing.T) {
+	c := newTestClient()
+	q := newQueue("q")
+	q.Timeout = 1 * time.Hour
+
+	stuck, _ := c.Submit(q, 100, nil, WithTimeout(10*time.Millisecond))
+	next, _ := c.Submit(q, 100, nil)
+
+	block := make(chan struct{})
+	defer close(block)
+	done := make(chan struct{})
+	dispatcher := dispatcher{
+		Queue:  q,
+		client: c,
+		Handler: func(ctx context.Context, job *Job) error {
+			if job.ID == stuck.ID {
+				<-block
+				return nil
+			}
+			done <- struct{}{}
+			return nil
+		},
+	}
+	dispatcher.Run()
+	defer dispatcher.Cancel(0)
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("worker slot was not freed")
+	}
+
+	j, _ := c.Job(stuck.ID)
+	if j.State != Dead || !j.TimedOut {
+		t.Errorf("job was not timed out: %s (timed out: %t)", j.State, j.TimedOut)
+	}
+
+	j, _ = c.Job(next.ID)
+	if j.TimedOut {
+		t.Error("job should not be timed out")
+	}
+}
+
+func TestDispatcherRun_Panic(t *testing.T) {
+	c := newTestClient()
+	q := newQueue("q")
+
+	panics := make(chan *PanicError, 1)
+	c.opts.PanicHandler = func(j *Job, err *PanicError) {
+		panics <- err
+	}
+
+	job, _ := c.Submit(q, 100, nil)
+	c.Submit(q, 100, nil)
+
+	done := make(chan struct{})
+	dispatcher := dispatcher{
+		Queue:  q,
+		client: c,
+		Handler: func(ctx context.Context, j *Job) error {
+			if j.ID == job.ID {
+				panic("oops")
+			}
+			done <- struct{}{}
+			return nil
+		},
+	}
+	dispatcher.Run()
+	defer dispatcher.Cancel(0)
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("worker slot was not freed")
+	}
+
+	if err := <-panics; err.Value != "oops" {
+		t.Error("unexpected panic value:", err.Value)
+	}
+
+	j, _ := c.Job(job.ID)
+	if j.State != Dead {
+		t.Error("job was not failed:", j.State)
+	}
+	if len(j.Attempts) != 1 || j.Attempts[0].Stack == "" {
+		t.Error("panic stack was not recorded")
+	}
+}
@@ -0,0 +1,92 @@
+package koda
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrTimeout is the error of an attempt that exceeded its timeout.
+	ErrTimeout = errors.New("koda: job timed out")
+	// ErrLeaseExpired is the error of an attempt whose lease expired before
+	// it completed.
+	ErrLeaseExpired = errors.New("koda: job lease expired")
+	// ErrCancelled is the error of an attempt that was still running when its
+	// worker was cancelled.
+	ErrCancelled = errors.New("koda: worker cancelled")
+	// ErrWorkerLost is the error of an attempt whose worker stopped sending
+	// heartbeats before the attempt completed.
+	ErrWorkerLost = errors.New("koda: worker stopped responding")
+	// ErrJobCanceled is the error of an attempt that was canceled by
+	// Client.CancelRunning.
+	ErrJobCanceled = errors.New("koda: job canceled")
+	// ErrJobNotFound is returned when fetching a job that does not exist.
+	ErrJobNotFound = errors.New("koda: job not found")
+	// ErrBatchNotFound is returned when fetching a batch that does not exist.
+	ErrBatchNotFound = errors.New("koda: batch not found")
+)
+
+// CorruptJobError is returned when a job's hash can not be parsed. Corrupt
+// jobs that are claimed by a worker are moved to the quarantine set.
+type CorruptJobError struct {
+	// The key of the job's hash
+	Key string
+	// The field that could not be parsed
+	Field string
+	Err   error
+}
+
+func (e *CorruptJobError) Error() string {
+	return fmt.Sprintf("koda: corrupt job %s: field %q: %s", e.Key, e.Field, e.Err)
+}
+
+func (e *CorruptJobError) Unwrap() error { return e.Err }
+
+// PanicError is the error of an attempt whose handler panicked.
+type PanicError struct {
+	// The value passed to panic
+	Value interface{}
+	// The stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("koda: handler panicked: %v", e.Value)
+}
+
+type permanentError struct {
+	err error
+}
+
+// Permanent wraps an error returned by a handler to indicate that the job
+// should not be retried. The job is placed in the Dead state, regardless of
+// Queue.MaxAttempts. Permanent returns nil if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type retryAfterError struct {
+	delay time.Duration
+	err   error
+}
+
+// RetryAfter wraps an error returned by a handler to override the delay
+// until the job's next attempt. RetryAfter returns nil if err is nil.
+func RetryAfter(d time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &retryAfterError{delay: d, err: err}
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
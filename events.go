@@ -0,0 +1,174 @@
+package koda
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// EventType is the type of change in a job's lifecycle.
+type EventType string
+
+const (
+	// EventCreated is published when a job is created.
+	EventCreated EventType = "created"
+	// EventQueued is published when a job is put on a priority queue, or
+	// returned to its queue after its worker stopped responding.
+	EventQueued EventType = "queued"
+	// EventDelayed is published when a job is put on a delayed queue.
+	EventDelayed EventType = "delayed"
+	// EventStarted is published when a worker begins an attempt.
+	EventStarted EventType = "started"
+	// EventSucceeded is published when an attempt succeeds.
+	EventSucceeded EventType = "succeeded"
+	// EventFailed is published when an attempt fails.
+	EventFailed EventType = "failed"
+	// EventRetried is published when a failed job is scheduled to be retried.
+	EventRetried EventType = "retried"
+	// EventDead is published when a failed job will not be retried.
+	EventDead EventType = "dead"
+	// EventCanceled is published when a job is canceled.
+	EventCanceled EventType = "canceled"
+)
+
+// Event is a change in a job's lifecycle.
+type Event struct {
+	Type  EventType `json:"type"`
+	JobID int       `json:"job_id"`
+	Queue string    `json:"queue"`
+	State JobState  `json:"state"`
+	Time  time.Time `json:"time"`
+
+	// The error of the attempt, for failed events and jobs returned to their
+	// queue
+	Error string `json:"error,omitempty"`
+}
+
+// EventFilter selects the events delivered by Client.Events. The zero value
+// selects every event.
+type EventFilter struct {
+	// Only events of jobs in this queue, if set
+	Queue string
+
+	// Only events of these types, if set
+	Types []EventType
+}
+
+func (f EventFilter) matches(e Event) bool {
+	if f.Queue != "" && f.Queue != e.Queue {
+		return false
+	}
+
+	if len(f.Types) == 0 {
+		return true
+	}
+
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Events returns a channel of the lifecycle events of every job, as selected
+// by filter. Events are delivered at most once, and only while subscribed.
+// The returned function unsubscribes, and closes the channel. It must be
+// called once the caller stops reading events, as the subscription holds a
+// redis connection.
+func (c *Client) Events(filter EventFilter) (<-chan Event, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.EventsContext(ctx, filter)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return events, cancel, nil
+}
+
+// EventsContext returns a channel of the lifecycle events of every job, as
+// selected by filter. The channel is closed once ctx is done, which must
+// happen once the caller stops reading events.
+func (c *Client) EventsContext(ctx context.Context, filter EventFilter) (<-chan Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	ch, unsubscribe, err := conn.Subscribe(c.eventsChannel())
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer unsubscribe()
+
+		for {
+			var msg string
+			var ok bool
+			select {
+			case msg, ok = <-ch:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			var e Event
+			if err := json.Unmarshal([]byte(msg), &e); err != nil || !filter.matches(e) {
+				continue
+			}
+
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// publishEvent notifies subscribers of a change in a job's lifecycle. Events
+// are informational, so failing to publish one is ignored. Schedulers working
+// the job's queue are woken when the job is delayed.
+func (c *Client) publishEvent(conn Conn, t EventType, j *Job, err error) {
+	if msg, ok := eventMessage(t, j, err); ok {
+		conn.Publish(c.eventsChannel(), msg)
+	}
+
+	if t == EventDelayed || t == EventRetried {
+		conn.Publish(c.scheduledChannel(j.Queue), strconv.Itoa(j.ID))
+	}
+}
+
+// eventMessage returns the message published for a change in a job's
+// lifecycle, and whether it could be encoded.
+func eventMessage(t EventType, j *Job, err error) (string, bool) {
+	e := Event{
+		Type:  t,
+		JobID: j.ID,
+		Queue: j.Queue,
+		State: j.State,
+		Time:  time.Now().UTC(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	msg, jsonErr := json.Marshal(e)
+	if jsonErr != nil {
+		return "", false
+	}
+
+	return string(msg), true
+}
@@ -0,0 +1,70 @@
+package koda
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := client.EventsContext(ctx, EventFilter{Queue: q.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Submit(Queue{Name: "other"}, 100, nil)
+	job, _ := client.Submit(q, 100, nil)
+
+	j, _ := client.wait(q)
+	client.fail(&j, q, errors.New("connection refused"))
+
+	expected := []EventType{EventCreated, EventQueued, EventStarted, EventFailed, EventRetried}
+	for _, typ := range expected {
+		e := nextEvent(t, events)
+		if e.Type != typ || e.JobID != job.ID || e.Queue != q.Name {
+			t.Errorf("got %s event of job %d in %q, want %s event of job %d", e.Type, e.JobID, e.Queue, typ, job.ID)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestEvents_Types(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 1}
+
+	events, cancel, err := client.Events(EventFilter{Types: []EventType{EventDead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, _ := client.Submit(q, 100, nil)
+	j, _ := client.wait(q)
+	client.fail(&j, q, errors.New("connection refused"))
+
+	e := nextEvent(t, events)
+	if e.Type != EventDead || e.JobID != job.ID || e.State != Dead {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	cancel()
+	for range events {
+	}
+}
@@ -1,29 +1,40 @@
 package mock
 
 import (
+	"encoding/json"
+	"math"
+	"path"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 )
 
 type Conn struct {
-	keys   map[string]string
-	lists  map[string][]string
-	hashes map[string]map[string]string
-	sets   map[string]map[string]float64 // map[member]score
-	lock   sync.RWMutex
+	keys          map[string]string
+	expiries      map[string]time.Time
+	lists         map[string][]string
+	hashes        map[string]map[string]string
+	sets          map[string]map[string]float64 // map[member]score
+	subscriptions []*subscription
+	lock          sync.RWMutex
 }
 
 func NewConn() *Conn {
 	return &Conn{
-		keys:   make(map[string]string),
-		lists:  make(map[string][]string),
-		hashes: make(map[string]map[string]string),
-		sets:   make(map[string]map[string]float64),
+		keys:     make(map[string]string),
+		expiries: make(map[string]time.Time),
+		lists:    make(map[string][]string),
+		hashes:   make(map[string]map[string]string),
+		sets:     make(map[string]map[string]float64),
 	}
 }
 
 func (c *Conn) Incr(key string) (int, error) {
+	return c.IncrBy(key, 1)
+}
+
+func (c *Conn) IncrBy(key string, n int) (int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
@@ -31,13 +42,13 @@ func (c *Conn) Incr(key string) (int, error) {
 		c.keys[key] = "0"
 	}
 
-	n, err := strconv.Atoi(c.keys[key])
+	cur, err := strconv.Atoi(c.keys[key])
 	if err != nil {
 		return 0, err
 	}
 
-	c.keys[key] = strconv.Itoa(n + 1)
-	return n + 1, nil
+	c.keys[key] = strconv.Itoa(cur + n)
+	return cur + n, nil
 }
 
 func (c *Conn) HGetAll(key string) ([]string, error) {
@@ -49,7 +60,7 @@ func (c *Conn) HGetAll(key string) ([]string, error) {
 		return nil, nil
 	}
 
-	pairs := make([]string, len(hash)*2)
+	pairs := make([]string, 0, len(hash)*2)
 	for k, v := range hash {
 		pairs = append(pairs, k)
 		pairs = append(pairs, v)
@@ -81,49 +92,91 @@ func (c *Conn) RPush(key string, value ...string) (int, error) {
 	return len(c.lists[key]), nil
 }
 
-func (c *Conn) BLPop(timeout time.Duration, keys ...string) ([]string, error) {
+// ClaimJob does not block, as a mock Conn is only used by a single process.
+func (c *Conn) ClaimJob(timeout time.Duration, wakeKey, dest string, score float64, keys ...string) ([]string, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	// TODO: add support for timeouts?
+	var claimed []string
+	queued := 0
 	for _, key := range keys {
-		if len(c.lists[key]) > 0 {
+		if claimed == nil && len(c.lists[key]) > 0 {
 			v := c.lists[key][0]
 			c.lists[key] = c.lists[key][1:]
-			return []string{key, v}, nil
+			c.zadd(dest, score, v)
+			claimed = []string{key, v}
 		}
+		queued += len(c.lists[key])
+	}
+
+	if wake := c.lists[wakeKey]; len(wake) > queued {
+		c.lists[wakeKey] = wake[len(wake)-queued:]
+	}
+	if queued == 0 {
+		delete(c.lists, wakeKey)
 	}
 
-	return nil, nil
+	return claimed, nil
 }
 
-func (c *Conn) ZAddNX(key string, score float64, member string) (int, error) {
+func (c *Conn) ZAdd(key string, score float64, member string) (int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	// handle the NX part
-	if set, ok := c.sets[key]; ok {
-		if _, ok := set[member]; ok {
-			return 0, nil
-		}
-	}
+	return c.zadd(key, score, member), nil
+}
 
+func (c *Conn) zadd(key string, score float64, member string) int {
 	if _, ok := c.sets[key]; !ok {
 		c.sets[key] = make(map[string]float64)
 	}
 
+	_, exists := c.sets[key][member]
+	c.sets[key][member] = score
+	if exists {
+		return 0
+	}
+
+	return 1
+}
+
+func (c *Conn) ZAddXX(key string, score float64, member string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.sets[key][member]; !ok {
+		return 0, nil
+	}
+
 	c.sets[key][member] = score
 	return 1, nil
 }
 
-func (c *Conn) ZPopByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) ([]string, error) {
+func (c *Conn) ZRem(key string, members ...string) (int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	if _, ok := c.sets[key]; !ok {
-		c.sets[key] = make(map[string]float64)
+	n := 0
+	for _, member := range members {
+		if _, ok := c.sets[key][member]; ok {
+			delete(c.sets[key], member)
+			n++
+		}
 	}
 
+	return n, nil
+}
+
+func (c *Conn) ZRangeByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.zrangeByScore(key, min, max, minIncl, maxIncl, offset, count), nil
+}
+
+// zrangeByScore returns the members of the sorted set in ascending score order.
+// A negative count returns all members after offset.
+func (c *Conn) zrangeByScore(key string, min, max float64, minIncl, maxIncl bool, offset, count int) []string {
 	type Item struct {
 		Member string
 		Score  float64
@@ -138,40 +191,542 @@ func (c *Conn) ZPopByScore(key string, min, max float64, minIncl, maxIncl bool,
 			continue
 		}
 
-		pos := 0
-		for i := range items {
-			if s > items[i].Score {
-				pos = i
-				break
-			}
-		}
-
-		item := Item{Member: m, Score: s}
-		items = append(items[:pos], append([]Item{item}, items[pos:]...)...)
+		items = append(items, Item{Member: m, Score: s})
 	}
 
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score == items[j].Score {
+			return items[i].Member < items[j].Member
+		}
+		return items[i].Score < items[j].Score
+	})
+
 	lo := offset
 	if len(items) < lo {
-		return nil, nil
+		return nil
 	}
 	hi := lo + count
-	if hi > len(items) {
+	if count < 0 || hi > len(items) {
 		hi = len(items)
 	}
 	items = items[lo:hi]
 
 	var members []string
 	for i := range items {
-		member := items[i].Member
-		members = append(members, member)
+		members = append(members, items[i].Member)
+	}
+
+	return members
+}
+
+func (c *Conn) ZPopByScoreZAdd(key string, min, max float64, minIncl, maxIncl bool, offset, count int, dest string, score float64) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	members := c.zrangeByScore(key, min, max, minIncl, maxIncl, offset, count)
+	for _, member := range members {
 		delete(c.sets[key], member)
+		c.zadd(dest, score, member)
 	}
 
 	return members, nil
 }
 
-func (c *Conn) Subscribe(channel string) (<-chan string, error) {
-	return nil, nil
+func (c *Conn) SubmitJob(idKey string, id int, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if idKey != "" {
+		n, _ := strconv.Atoi(c.keys[idKey])
+		id = n + 1
+		c.keys[idKey] = strconv.Itoa(id)
+	}
+
+	jobKey := keyPrefix + strconv.Itoa(id)
+	if _, ok := c.hashes[jobKey]; !ok {
+		c.hashes[jobKey] = make(map[string]string)
+	}
+	for k, v := range fields {
+		c.hashes[jobKey][k] = v
+	}
+	if idKey != "" {
+		c.hashes[jobKey]["id"] = strconv.Itoa(id)
+	}
+
+	if listKey != "" {
+		c.lists[listKey] = append(c.lists[listKey], jobKey)
+		if wakeKey != "" {
+			c.lists[wakeKey] = append(c.lists[wakeKey], jobKey)
+		}
+	}
+	if delayedKey != "" {
+		if _, ok := c.sets[delayedKey][jobKey]; !ok {
+			c.zadd(delayedKey, score, jobKey)
+		}
+	}
+
+	return id, nil
+}
+
+func (c *Conn) SubmitUniqueJob(uniqueKey string, ttl time.Duration, idKey, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) (int, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire(uniqueKey)
+	if existing, ok := c.keys[uniqueKey]; ok {
+		id, err := strconv.Atoi(existing)
+		return id, false, err
+	}
+
+	id := c.submitNewJob(idKey, keyPrefix, fields, listKey, wakeKey, delayedKey, score)
+	c.keys[uniqueKey] = strconv.Itoa(id)
+	if ttl > 0 {
+		c.expiries[uniqueKey] = time.Now().Add(ttl)
+	}
+
+	return id, true, nil
+}
+
+func (c *Conn) DebounceJob(debounceKey string, ttl time.Duration, idKey, keyPrefix string, fields map[string]string, updateFields []string, delayedKey string, score float64) (int, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire(debounceKey)
+	if existing, ok := c.keys[debounceKey]; ok {
+		jobKey := keyPrefix + existing
+		if _, ok := c.sets[delayedKey][jobKey]; ok {
+			for _, f := range updateFields {
+				c.hashes[jobKey][f] = fields[f]
+			}
+			c.zadd(delayedKey, score, jobKey)
+			c.expiries[debounceKey] = time.Now().Add(ttl)
+
+			id, err := strconv.Atoi(existing)
+			return id, false, err
+		}
+	}
+
+	id := c.submitNewJob(idKey, keyPrefix, fields, "", "", delayedKey, score)
+	c.keys[debounceKey] = strconv.Itoa(id)
+	c.expiries[debounceKey] = time.Now().Add(ttl)
+
+	return id, true, nil
+}
+
+// submitNewJob allocates an id for a job by incrementing idKey, sets fields on
+// its hash, and pushes it onto listKey and wakeKey and adds it to delayedKey,
+// if set.
+func (c *Conn) submitNewJob(idKey, keyPrefix string, fields map[string]string, listKey, wakeKey, delayedKey string, score float64) int {
+	n, _ := strconv.Atoi(c.keys[idKey])
+	id := n + 1
+	c.keys[idKey] = strconv.Itoa(id)
+
+	jobKey := keyPrefix + strconv.Itoa(id)
+	c.hashes[jobKey] = make(map[string]string)
+	for k, v := range fields {
+		c.hashes[jobKey][k] = v
+	}
+	c.hashes[jobKey]["id"] = strconv.Itoa(id)
+
+	if listKey != "" {
+		c.lists[listKey] = append(c.lists[listKey], jobKey)
+		if wakeKey != "" {
+			c.lists[wakeKey] = append(c.lists[wakeKey], jobKey)
+		}
+	}
+	if delayedKey != "" {
+		c.zadd(delayedKey, score, jobKey)
+	}
+
+	return id
+}
+
+func (c *Conn) MoveJob(jobKey string, states []string, fromList, fromZSet, toList, wakeKey, toZSet string, score float64, fields map[string]string, onlyIfRemoved bool) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	allowed := false
+	for _, state := range states {
+		if state == c.hashes[jobKey]["state"] {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return false, nil
+	}
+
+	removed := false
+	if fromList != "" {
+		var list []string
+		for _, v := range c.lists[fromList] {
+			if v == jobKey {
+				removed = true
+			} else {
+				list = append(list, v)
+			}
+		}
+		c.lists[fromList] = list
+	}
+	if fromZSet != "" {
+		if _, ok := c.sets[fromZSet][jobKey]; ok {
+			delete(c.sets[fromZSet], jobKey)
+			removed = true
+		}
+	}
+
+	if onlyIfRemoved && !removed {
+		return false, nil
+	}
+
+	for k, v := range fields {
+		c.hashes[jobKey][k] = v
+	}
+
+	if toList != "" {
+		c.lists[toList] = append(c.lists[toList], jobKey)
+		if wakeKey != "" {
+			c.lists[wakeKey] = append(c.lists[wakeKey], jobKey)
+		}
+	}
+	if toZSet != "" {
+		c.zadd(toZSet, score, jobKey)
+	}
+
+	return true, nil
+}
+
+func (c *Conn) CompleteJob(jobKey string, states []string, fromList, fromZSet string, fields map[string]string, jobs []struct {
+	Key     string
+	Fields  map[string]string
+	ListKey string
+	WakeKey string
+}, batchKey, counter, callbackState string) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(states) > 0 {
+		found := false
+		for _, state := range states {
+			if c.hashes[jobKey]["state"] == state {
+				found = true
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if fromList != "" || fromZSet != "" {
+		removed := 0
+		if fromList != "" {
+			removed += c.lrem(fromList, jobKey)
+		}
+		if _, ok := c.sets[fromZSet][jobKey]; ok {
+			delete(c.sets[fromZSet], jobKey)
+			removed++
+		}
+		if removed == 0 {
+			return false, nil
+		}
+	}
+
+	c.hset(jobKey, fields)
+	for _, job := range jobs {
+		c.hset(job.Key, job.Fields)
+		c.lists[job.ListKey] = append(c.lists[job.ListKey], job.Key)
+		c.lists[job.WakeKey] = append(c.lists[job.WakeKey], job.Key)
+	}
+
+	if batchKey == "" {
+		return true, nil
+	}
+
+	batch := c.hashes[batchKey]
+	if batch == nil {
+		batch = make(map[string]string)
+		c.hashes[batchKey] = batch
+	}
+
+	n, _ := strconv.Atoi(batch[counter])
+	batch[counter] = strconv.Itoa(n + 1)
+
+	pending, _ := strconv.Atoi(batch["pending"])
+	batch["pending"] = strconv.Itoa(pending - 1)
+	if pending-1 != 0 {
+		return true, nil
+	}
+
+	summary := make(map[string]int)
+	for k, v := range batch {
+		if n, err := strconv.Atoi(v); err == nil {
+			summary[k] = n
+		}
+	}
+	payload, _ := json.Marshal(summary)
+
+	callbackKey := batch["callback_key"]
+	c.hset(callbackKey, map[string]string{
+		"payload": string(payload),
+		"state":   callbackState,
+	})
+	c.lists[batch["callback_list"]] = append(c.lists[batch["callback_list"]], callbackKey)
+	if wakeKey, ok := batch["callback_wake"]; ok {
+		c.lists[wakeKey] = append(c.lists[wakeKey], callbackKey)
+	}
+
+	return true, nil
+}
+
+func (c *Conn) hset(key string, fields map[string]string) {
+	if _, ok := c.hashes[key]; !ok {
+		c.hashes[key] = make(map[string]string)
+	}
+	for k, v := range fields {
+		c.hashes[key][k] = v
+	}
+}
+
+func (c *Conn) lrem(key string, value string) int {
+	n := 0
+	list := c.lists[key][:0]
+	for _, v := range c.lists[key] {
+		if v == value {
+			n++
+			continue
+		}
+		list = append(list, v)
+	}
+	c.lists[key] = list
+
+	return n
+}
+
+func (c *Conn) PromoteJobs(key string, max float64, listKeyPrefix, wakeKey string, count int) (float64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, jobKey := range c.zrangeByScore(key, math.Inf(-1), max, true, true, 0, count) {
+		priority, ok := c.hashes[jobKey]["priority"]
+		if !ok {
+			priority = "0"
+		}
+
+		listKey := listKeyPrefix + priority
+		c.lists[listKey] = append(c.lists[listKey], jobKey)
+		c.lists[wakeKey] = append(c.lists[wakeKey], jobKey)
+		delete(c.sets[key], jobKey)
+	}
+
+	next := math.Inf(1)
+	for _, score := range c.sets[key] {
+		next = math.Min(next, score)
+	}
+
+	return next, nil
+}
+
+// expire deletes key if it has expired.
+func (c *Conn) expire(key string) {
+	if t, ok := c.expiries[key]; ok && !time.Now().Before(t) {
+		delete(c.keys, key)
+		delete(c.expiries, key)
+	}
+}
+
+func (c *Conn) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire(key)
+	if _, ok := c.keys[key]; ok {
+		return false, nil
+	}
+
+	c.keys[key] = value
+	c.expiries[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (c *Conn) ExpireIfEqual(key, value string, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire(key)
+	if v, ok := c.keys[key]; !ok || v != value {
+		return false, nil
+	}
+
+	if ttl > 0 {
+		c.expiries[key] = time.Now().Add(ttl)
+	} else {
+		delete(c.keys, key)
+		delete(c.expiries, key)
+	}
+
+	return true, nil
+}
+
+func (c *Conn) AcquireLock(key, value string, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire(key)
+	if v, ok := c.keys[key]; ok && v != value {
+		return false, nil
+	}
+
+	c.keys[key] = value
+	c.expiries[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+// subscription is a subscriber to a channel, or to every channel matching a
+// pattern. Exactly one of payloads and messages is set.
+type subscription struct {
+	channel  string
+	pattern  bool
+	payloads chan string
+	messages chan []string
+}
+
+func (s *subscription) matches(channel string) bool {
+	if !s.pattern {
+		return s.channel == channel
+	}
+
+	ok, _ := path.Match(s.channel, channel)
+	return ok
+}
+
+// Publish delivers message to each subscriber of channel. Messages are
+// dropped for subscribers whose buffer is full.
+func (c *Conn) Publish(channel string, message string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.publish(channel, message), nil
+}
+
+func (c *Conn) publish(channel string, message string) int {
+	n := 0
+	for _, s := range c.subscriptions {
+		if !s.matches(channel) {
+			continue
+		}
+
+		n++
+		if s.payloads != nil {
+			select {
+			case s.payloads <- message:
+			default:
+			}
+		} else {
+			select {
+			case s.messages <- []string{channel, message}:
+			default:
+			}
+		}
+	}
+
+	return n
+}
+
+// Pipeline queues commands, which are executed while holding the Conn's lock
+// when Exec is called.
+type Pipeline struct {
+	conn *Conn
+	cmds []func()
+}
+
+func (c *Conn) Pipeline() interface {
+	HSetAll(key string, fields map[string]string)
+	RPush(key string, value ...string)
+	ZAdd(key string, score float64, member string)
+	Publish(channel string, message string)
+	Exec() error
+} {
+	return &Pipeline{conn: c}
+}
+
+func (p *Pipeline) HSetAll(key string, fields map[string]string) {
+	p.cmds = append(p.cmds, func() {
+		if _, ok := p.conn.hashes[key]; !ok {
+			p.conn.hashes[key] = make(map[string]string)
+		}
+		for k, v := range fields {
+			p.conn.hashes[key][k] = v
+		}
+	})
+}
+
+func (p *Pipeline) RPush(key string, value ...string) {
+	p.cmds = append(p.cmds, func() {
+		p.conn.lists[key] = append(p.conn.lists[key], value...)
+	})
+}
+
+func (p *Pipeline) ZAdd(key string, score float64, member string) {
+	p.cmds = append(p.cmds, func() {
+		p.conn.zadd(key, score, member)
+	})
+}
+
+func (p *Pipeline) Publish(channel string, message string) {
+	p.cmds = append(p.cmds, func() {
+		p.conn.publish(channel, message)
+	})
+}
+
+func (p *Pipeline) Exec() error {
+	p.conn.lock.Lock()
+	defer p.conn.lock.Unlock()
+
+	for _, cmd := range p.cmds {
+		cmd()
+	}
+	p.cmds = nil
+
+	return nil
+}
+
+func (c *Conn) Subscribe(channel string) (<-chan string, func() error, error) {
+	s := &subscription{channel: channel, payloads: make(chan string, 100)}
+	return s.payloads, c.subscribe(s), nil
+}
+
+func (c *Conn) PSubscribe(pattern string) (<-chan []string, func() error, error) {
+	s := &subscription{channel: pattern, pattern: true, messages: make(chan []string, 100)}
+	return s.messages, c.subscribe(s), nil
+}
+
+// subscribe adds s to the subscribers, and returns a function that removes
+// it and closes its channel.
+func (c *Conn) subscribe(s *subscription) func() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.subscriptions = append(c.subscriptions, s)
+
+	return func() error {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		for i := range c.subscriptions {
+			if c.subscriptions[i] != s {
+				continue
+			}
+
+			c.subscriptions = append(c.subscriptions[:i], c.subscriptions[i+1:]...)
+			if s.payloads != nil {
+				close(s.payloads)
+			} else {
+				close(s.messages)
+			}
+			break
+		}
+
+		return nil
+	}
 }
 
 func (c *Conn) Close() error { return nil }
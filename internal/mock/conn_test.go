@@ -0,0 +1,44 @@
+package mock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimJob_TrimsWakeList(t *testing.T) {
+	c := NewConn()
+	keys := []string{"queue:100", "queue:0"}
+
+	for i := 0; i < 10; i++ {
+		c.SubmitJob("id", 0, "jobs:", nil, keys[i%2], "wake", "", 0)
+	}
+
+	// Removing a job leaves its value on the wake list
+	c.CompleteJob("jobs:1", nil, "queue:100", "", nil, nil, "", "", "")
+
+	for claimed := 1; claimed <= 9; claimed++ {
+		if res, _ := c.ClaimJob(time.Second, "wake", "processing", 0, keys...); len(res) != 2 {
+			t.Fatalf("claim %d: no job was claimed", claimed)
+		}
+
+		if n := len(c.lists["wake"]); n != 9-claimed {
+			t.Errorf("claim %d: wake list has %d values, want %d", claimed, n, 9-claimed)
+		}
+	}
+
+	if res, _ := c.ClaimJob(time.Second, "wake", "processing", 0, keys...); res != nil {
+		t.Errorf("unexpected claim: %v", res)
+	}
+	if _, ok := c.lists["wake"]; ok {
+		t.Error("wake list should be deleted once every list is empty")
+	}
+
+	if n := len(c.sets["processing"]); n != 9 {
+		t.Errorf("processing set has %d jobs, want %d", n, 9)
+	}
+
+	c.SubmitJob("id", 0, "jobs:", nil, keys[0], "wake", "", 0)
+	if n := len(c.lists["wake"]); n != 1 {
+		t.Errorf("wake list has %d values after submit, want 1", n)
+	}
+}
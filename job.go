@@ -2,8 +2,10 @@ package koda
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,6 +23,8 @@ const (
 	Finished = 3
 	// Dead jobs are jobs that have failed > Qeuue.MaxAttempts
 	Dead = 4
+	// Canceled jobs were removed from their queue before being processed.
+	Canceled = 5
 )
 
 func (s JobState) String() string {
@@ -35,24 +39,204 @@ func (s JobState) String() string {
 		return "Finished"
 	case Dead:
 		return "Dead"
+	case Canceled:
+		return "Canceled"
 	default:
-		panic(fmt.Sprintf("Unknown state: %d", s))
+		return fmt.Sprintf("JobState(%d)", s)
 	}
 }
 
+func (s JobState) valid() bool {
+	return s >= Initial && s <= Canceled
+}
+
 // Job represents a koda job. Job should not be instantiated directly. Instead
 // use Client.CreateJob, Client.Submit and Client.SubmitDelayed to create a Job.
 type Job struct {
 	ID             int
+	Queue          string
 	State          JobState
 	DelayedUntil   time.Time
 	CreationTime   time.Time
+	StartTime      time.Time
 	CompletionTime time.Time
 	Priority       int
 	NumAttempts    int
+	LeaseExpiry    time.Time
+	Metadata       Metadata
+
+	// The delay before the current attempt, if it is a retry
+	RetryDelay time.Duration
+
+	// The maximum duration of a single attempt, overriding Queue.Timeout
+	Timeout time.Duration
+
+	// Whether the most recent attempt failed by exceeding its timeout
+	TimedOut bool
+
+	// The error of the most recent failed attempt
+	LastError string
+
+	// Every attempt at processing the job, in order
+	Attempts []Attempt
+
+	// Submissions of a job with the same unique key return this job, while it
+	// is not finished, and for UniqueTTL after it finishes
+	UniqueKey string
+	UniqueTTL time.Duration
+
+	// The batch the job was submitted with, if any. See Client.SubmitBatch.
+	BatchID int
 
 	payload    interface{}
 	rawPayload string
+
+	// Set by WithDebounce and WithThrottle
+	debounce *submitWindow
+	throttle *submitWindow
+
+	// Submitted when the job finishes, or when it dies
+	onSuccess []followUp
+	onDeath   *followUp
+}
+
+// submitWindow collapses submissions of jobs with the same key within a
+// window.
+type submitWindow struct {
+	Key    string
+	Window time.Duration
+}
+
+// followUp is a job submitted when another job finishes or dies. See
+// WithOnSuccess and WithOnDeath.
+type followUp struct {
+	Queue    string      `json:"queue"`
+	Priority int         `json:"priority"`
+	Payload  interface{} `json:"payload"`
+}
+
+// UnmarshalJSON leaves the payload encoded, so that it is submitted unchanged.
+func (f *followUp) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Queue    string          `json:"queue"`
+		Priority int             `json:"priority"`
+		Payload  json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*f = followUp{Queue: v.Queue, Priority: v.Priority, Payload: v.Payload}
+	return nil
+}
+
+// Attempt records a single attempt at processing a job.
+type Attempt struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+
+	// The worker that processed the attempt
+	WorkerID string `json:"worker_id"`
+
+	// The error the attempt failed with, if any
+	Error string `json:"error,omitempty"`
+
+	// The stack trace of the handler's panic, if it panicked
+	Stack string `json:"stack,omitempty"`
+}
+
+// startAttempt records the start of a new attempt by the given worker.
+func (j *Job) startAttempt(workerID string) {
+	j.StartTime = time.Now().UTC()
+	j.Attempts = append(j.Attempts, Attempt{
+		StartTime: j.StartTime,
+		WorkerID:  workerID,
+	})
+}
+
+// endAttempt records the end of the current attempt, which failed if err
+// is not nil.
+func (j *Job) endAttempt(err error) {
+	if len(j.Attempts) == 0 {
+		return
+	}
+
+	// Copy the attempts, as they may be shared with other copies of the job
+	attempts := make([]Attempt, len(j.Attempts))
+	copy(attempts, j.Attempts)
+
+	attempt := &attempts[len(attempts)-1]
+	attempt.EndTime = time.Now().UTC()
+	if err != nil {
+		attempt.Error = err.Error()
+		j.LastError = attempt.Error
+	}
+
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		attempt.Stack = string(panicErr.Stack)
+	}
+
+	j.Attempts = attempts
+}
+
+// SubmitOption configures a job when it is created.
+type SubmitOption func(j *Job)
+
+// WithTimeout sets the maximum duration of a single attempt of the job,
+// overriding Queue.Timeout.
+func WithTimeout(d time.Duration) SubmitOption {
+	return func(j *Job) {
+		j.Timeout = d
+	}
+}
+
+// WithUniqueKey makes the job unique by key. While a job with the same key is
+// Queued or Working, and for ttl after it finishes, submitting the job returns
+// the existing job instead of creating a new one.
+func WithUniqueKey(key string, ttl time.Duration) SubmitOption {
+	return func(j *Job) {
+		j.UniqueKey = key
+		j.UniqueTTL = ttl
+	}
+}
+
+// WithOnSuccess submits a job to queue, with the given priority and payload,
+// when the job finishes successfully. The follow-up job is submitted
+// atomically with the job being marked Finished, and carries the job's
+// Metadata. It may be given more than once, to submit several jobs.
+func WithOnSuccess(queue Queue, priority int, payload interface{}) SubmitOption {
+	return func(j *Job) {
+		j.onSuccess = append(j.onSuccess, followUp{Queue: queue.Name, Priority: priority, Payload: payload})
+	}
+}
+
+// WithOnDeath submits a job to queue, with the given priority and payload,
+// when the job is placed in the Dead state. The follow-up job is submitted
+// atomically with the job being marked Dead, and carries the job's Metadata.
+func WithOnDeath(queue Queue, priority int, payload interface{}) SubmitOption {
+	return func(j *Job) {
+		j.onDeath = &followUp{Queue: queue.Name, Priority: priority, Payload: payload}
+	}
+}
+
+// WithDebounce collapses submissions of jobs with the same key into a single
+// job, which is processed once no job with the key has been submitted for d.
+// Until then, submitting the job returns the existing job, with its payload
+// replaced. d must be at least a millisecond.
+func WithDebounce(key string, d time.Duration) SubmitOption {
+	return func(j *Job) {
+		j.debounce = &submitWindow{Key: key, Window: d}
+	}
+}
+
+// WithThrottle limits jobs with the same key to one per window d. Within d of
+// a job's submission, submitting the job returns the existing job instead of
+// creating a new one. d must be at least a millisecond.
+func WithThrottle(key string, d time.Duration) SubmitOption {
+	return func(j *Job) {
+		j.throttle = &submitWindow{Key: key, Window: d}
+	}
 }
 
 // UnmarshalPayload will unmarshal the associated payload into v.
@@ -63,12 +247,22 @@ func (j *Job) UnmarshalPayload(v interface{}) error {
 func (j *Job) hash() (map[string]string, error) {
 	hash := map[string]string{
 		"id":              strconv.Itoa(int(j.ID)),
+		"queue":           j.Queue,
 		"state":           strconv.Itoa(int(j.State)),
-		"delayed_until":   strconv.Itoa(int(j.DelayedUntil.Unix())),
-		"creation_time":   strconv.Itoa(int(j.CreationTime.Unix())),
-		"completion_time": strconv.Itoa(int(j.CompletionTime.Unix())),
+		"delayed_until":   formatTime(j.DelayedUntil),
+		"creation_time":   formatTime(j.CreationTime),
+		"start_time":      formatTime(j.StartTime),
+		"completion_time": formatTime(j.CompletionTime),
 		"priority":        strconv.Itoa(int(j.Priority)),
 		"num_attempts":    strconv.Itoa(int(j.NumAttempts)),
+		"lease_expiry":    formatTime(j.LeaseExpiry),
+		"retry_delay":     strconv.FormatInt(int64(j.RetryDelay), 10),
+		"timeout":         strconv.FormatInt(int64(j.Timeout), 10),
+		"timed_out":       strconv.FormatBool(j.TimedOut),
+		"last_error":      j.LastError,
+		"unique_key":      j.UniqueKey,
+		"unique_ttl":      strconv.FormatInt(int64(j.UniqueTTL), 10),
+		"batch_id":        strconv.Itoa(j.BatchID),
 	}
 
 	jsonPayload, err := json.Marshal(j.payload)
@@ -77,46 +271,191 @@ func (j *Job) hash() (map[string]string, error) {
 	}
 
 	hash["payload"] = string(jsonPayload)
+
+	jsonMetadata, err := json.Marshal(j.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	hash["metadata"] = string(jsonMetadata)
+
+	jsonAttempts, err := json.Marshal(j.Attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	hash["attempts"] = string(jsonAttempts)
+
+	jsonOnSuccess, err := json.Marshal(j.onSuccess)
+	if err != nil {
+		return nil, err
+	}
+
+	hash["on_success"] = string(jsonOnSuccess)
+
+	jsonOnDeath, err := json.Marshal(j.onDeath)
+	if err != nil {
+		return nil, err
+	}
+
+	hash["on_death"] = string(jsonOnDeath)
 	return hash, nil
 }
 
+// timePrecision is the precision with which a job's timestamps are stored
+const timePrecision = time.Millisecond
+
+// formatTime formats t as Unix seconds, with a fractional part of
+// milliseconds. The fractional part is always positive, so negative times are
+// formatted as the whole second before t, plus the fraction.
+func formatTime(t time.Time) string {
+	return fmt.Sprintf("%d.%03d", t.Unix(), t.Nanosecond()/int(timePrecision))
+}
+
+// parseTime parses a time formatted by formatTime, or as whole Unix seconds,
+// as stored by earlier versions.
+func parseTime(s string) (time.Time, error) {
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec uint64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+
+		nsec, err = strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(sec, int64(nsec)).UTC(), nil
+}
+
+// timeout returns the maximum duration of a single attempt of the job
+func (j *Job) timeout(queue Queue) time.Duration {
+	if j.Timeout > 0 {
+		return j.Timeout
+	}
+
+	return queue.Timeout
+}
+
+// jobFields returns the given fields of the job's hash. If no fields are
+// given, all fields are returned.
+func jobFields(j *Job, fields ...string) (map[string]string, error) {
+	hash, err := j.hash()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return hash, nil
+	}
+
+	out := make(map[string]string)
+	for _, f := range fields {
+		out[f] = hash[f]
+	}
+
+	return out, nil
+}
+
+// jobUnmarshaller parses the fields of a job's hash. Once a field fails to
+// parse, Field and Err are set, and all subsequent parsing is skipped.
 type jobUnmarshaller struct {
-	Err error
+	Props map[string]string
+	Field string
+	Err   error
+}
+
+func (u *jobUnmarshaller) fail(field string, err error) {
+	u.Field = field
+	u.Err = err
 }
 
-func (u *jobUnmarshaller) atoi(s string) int {
+func (u *jobUnmarshaller) atoi(field string) int {
+	s := u.Props[field]
 	if u.Err != nil || s == "" {
 		return 0
 	}
 
 	val, err := strconv.Atoi(s)
 	if err != nil {
-		u.Err = err
+		u.fail(field, err)
 	}
 
 	return val
 }
 
-func (u *jobUnmarshaller) parseJSON(s string) interface{} {
+func (u *jobUnmarshaller) atod(field string) time.Duration {
+	s := u.Props[field]
 	if u.Err != nil || s == "" {
-		return nil
+		return 0
 	}
 
-	var val interface{}
-	if err := json.Unmarshal([]byte(s), &val); err != nil {
-		u.Err = err
+	val, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		u.fail(field, err)
+	}
+
+	return time.Duration(val)
+}
+
+func (u *jobUnmarshaller) atob(field string) bool {
+	s := u.Props[field]
+	if u.Err != nil || s == "" {
+		return false
+	}
+
+	val, err := strconv.ParseBool(s)
+	if err != nil {
+		u.fail(field, err)
 	}
 
 	return val
 }
 
-func (u *jobUnmarshaller) atot(s string) time.Time {
-	secs := u.atoi(s)
-	if u.Err != nil {
+func (u *jobUnmarshaller) atot(field string) time.Time {
+	s := u.Props[field]
+	if u.Err != nil || s == "" {
 		return time.Time{}
 	}
 
-	return time.Unix(int64(secs), 0).UTC()
+	val, err := parseTime(s)
+	if err != nil {
+		u.fail(field, err)
+	}
+
+	return val
+}
+
+func (u *jobUnmarshaller) state(field string) JobState {
+	state := JobState(u.atoi(field))
+	if u.Err == nil && !state.valid() {
+		u.fail(field, fmt.Errorf("unknown state: %d", state))
+	}
+
+	return state
+}
+
+func (u *jobUnmarshaller) parseJSON(field string, v interface{}) {
+	s := u.Props[field]
+	if u.Err != nil || s == "" {
+		return
+	}
+
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		u.fail(field, err)
+	}
 }
 
 func unmarshalJob(c Conn, key string) (*Job, error) {
@@ -126,25 +465,43 @@ func unmarshalJob(c Conn, key string) (*Job, error) {
 		return nil, err
 	}
 
+	if len(results) == 0 {
+		return nil, ErrJobNotFound
+	}
+
 	for i := 0; i < len(results); i += 2 {
 		propMap[results[i]] = results[i+1]
 	}
 
-	u := jobUnmarshaller{}
+	u := jobUnmarshaller{Props: propMap}
 	job := Job{
-		ID:             u.atoi(propMap["id"]),
-		State:          JobState(u.atoi(propMap["state"])),
-		DelayedUntil:   u.atot(propMap["delayed_until"]),
-		CreationTime:   u.atot(propMap["creation_time"]),
-		CompletionTime: u.atot(propMap["completion_time"]),
-		Priority:       u.atoi(propMap["priority"]),
-		NumAttempts:    u.atoi(propMap["num_attempts"]),
-		payload:        u.parseJSON(propMap["payload"]),
+		ID:             u.atoi("id"),
+		Queue:          propMap["queue"],
+		State:          u.state("state"),
+		DelayedUntil:   u.atot("delayed_until"),
+		CreationTime:   u.atot("creation_time"),
+		StartTime:      u.atot("start_time"),
+		CompletionTime: u.atot("completion_time"),
+		Priority:       u.atoi("priority"),
+		NumAttempts:    u.atoi("num_attempts"),
+		LeaseExpiry:    u.atot("lease_expiry"),
+		RetryDelay:     u.atod("retry_delay"),
+		Timeout:        u.atod("timeout"),
+		TimedOut:       u.atob("timed_out"),
+		LastError:      propMap["last_error"],
+		UniqueKey:      propMap["unique_key"],
+		UniqueTTL:      u.atod("unique_ttl"),
+		BatchID:        u.atoi("batch_id"),
 		rawPayload:     propMap["payload"],
 	}
+	u.parseJSON("metadata", &job.Metadata)
+	u.parseJSON("attempts", &job.Attempts)
+	u.parseJSON("payload", &job.payload)
+	u.parseJSON("on_success", &job.onSuccess)
+	u.parseJSON("on_death", &job.onDeath)
 
 	if u.Err != nil {
-		panic(fmt.Sprintf("error during unmarshalling: %s", u.Err))
+		return nil, &CorruptJobError{Key: key, Field: u.Field, Err: u.Err}
 	}
 
 	return &job, nil
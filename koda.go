@@ -1,9 +1,13 @@
 package koda
 
 import (
+	"context"
+	"fmt"
 	"net/url"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/redis.v3"
@@ -23,6 +27,10 @@ func NewClient(opts *Options) *Client {
 		opts.Prefix = "koda"
 	}
 
+	if opts.WorkerTimeout == 0 {
+		opts.WorkerTimeout = 30 * time.Second
+	}
+
 	if opts.ConnFactory == nil {
 		url, err := url.Parse(opts.URL)
 		db, _ := strconv.Atoi(url.Path)
@@ -41,12 +49,26 @@ func NewClient(opts *Options) *Client {
 		}
 	}
 
-	return &Client{
-		opts: opts,
+	c := &Client{
+		opts:     opts,
+		workerID: newWorkerID(),
 		connPool: sync.Pool{New: func() interface{} {
 			return opts.ConnFactory()
 		}},
 	}
+	c.scheduler = &scheduler{client: c}
+
+	return c
+}
+
+var numWorkerIDs int32
+
+// newWorkerID returns an identifier that is unique to this client across
+// all processes
+func newWorkerID() string {
+	hostname, _ := os.Hostname()
+	n := atomic.AddInt32(&numWorkerIDs, 1)
+	return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), n)
 }
 
 // Configure the DefaultClient with the given Options
@@ -55,13 +77,57 @@ func Configure(opts *Options) {
 }
 
 // Submit creates a job and puts it on the priority queue.
-func Submit(queue string, priority int, payload interface{}) (Job, error) {
-	return DefaultClient.Submit(Queue{Name: queue}, priority, payload)
+func Submit(queue string, priority int, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return DefaultClient.Submit(Queue{Name: queue}, priority, payload, opts...)
+}
+
+// SubmitContext creates a job and puts it on the priority queue. The job will
+// carry any Metadata attached to ctx.
+func SubmitContext(ctx context.Context, queue string, priority int, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return DefaultClient.SubmitContext(ctx, Queue{Name: queue}, priority, payload, opts...)
 }
 
 // SubmitDelayed creates a job and puts it on the delayed queue.
-func SubmitDelayed(queue string, d time.Duration, payload interface{}) (Job, error) {
-	return DefaultClient.SubmitDelayed(Queue{Name: queue}, d, payload)
+func SubmitDelayed(queue string, d time.Duration, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return DefaultClient.SubmitDelayed(Queue{Name: queue}, d, payload, opts...)
+}
+
+// SubmitDelayedContext creates a job and puts it on the delayed queue. The job
+// will carry any Metadata attached to ctx.
+func SubmitDelayedContext(ctx context.Context, queue string, d time.Duration, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return DefaultClient.SubmitDelayedContext(ctx, Queue{Name: queue}, d, payload, opts...)
+}
+
+// SubmitAt creates a job and puts it on the delayed queue, to be processed at
+// time t.
+func SubmitAt(queue string, t time.Time, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return DefaultClient.SubmitAt(Queue{Name: queue}, t, payload, opts...)
+}
+
+// SubmitAtContext creates a job and puts it on the delayed queue, to be
+// processed at time t. The job will carry any Metadata attached to ctx.
+func SubmitAtContext(ctx context.Context, queue string, t time.Time, payload interface{}, opts ...SubmitOption) (Job, error) {
+	return DefaultClient.SubmitAtContext(ctx, Queue{Name: queue}, t, payload, opts...)
+}
+
+// SubmitMany creates a job for each request, and puts it on the priority
+// queue, or the delayed queue if the request's At is set.
+func SubmitMany(queue string, reqs []SubmitRequest) ([]Job, error) {
+	return DefaultClient.SubmitMany(Queue{Name: queue}, reqs)
+}
+
+// SubmitManyContext creates a job for each request, and puts it on the
+// priority queue, or the delayed queue if the request's At is set. The jobs
+// will carry any Metadata attached to ctx.
+func SubmitManyContext(ctx context.Context, queue string, reqs []SubmitRequest) ([]Job, error) {
+	return DefaultClient.SubmitManyContext(ctx, Queue{Name: queue}, reqs)
+}
+
+// SubmitBatch creates a job for each request, and tracks them as a batch.
+// Once every job has finished, died or been canceled, a callback job is put on
+// callback.Queue, with the Batch as its payload.
+func SubmitBatch(queue string, reqs []SubmitRequest, callback BatchCallback) (Batch, []Job, error) {
+	return DefaultClient.SubmitBatch(Queue{Name: queue}, reqs, callback)
 }
 
 // Register a given HandlerFunc with a queue
@@ -73,14 +139,42 @@ func Register(queue string, numWorkers int, f HandlerFunc) {
 	DefaultClient.Register(q, f)
 }
 
+// RegisterContext registers a given ContextHandlerFunc with a queue
+func RegisterContext(queue string, numWorkers int, f ContextHandlerFunc) {
+	q := Queue{
+		Name:       queue,
+		NumWorkers: numWorkers,
+	}
+	DefaultClient.RegisterContext(q, f)
+}
+
+// RegisterPeriodic submits a job to a queue at every occurrence of the
+// schedule spec. See Client.RegisterPeriodic.
+func RegisterPeriodic(queue string, spec string, payload interface{}, opts ...SubmitOption) error {
+	return DefaultClient.RegisterPeriodic(Queue{Name: queue}, spec, payload, opts...)
+}
+
 // Work will begin processing any registered queues in a separate goroutine.
 // Use returned Canceller to stop any outstanding workers.
 func Work() Canceller {
 	return DefaultClient.Work()
 }
 
+// WorkContext will begin processing any registered queues in a separate
+// goroutine. Outstanding workers are cancelled when ctx is done, or by using
+// the returned Canceller.
+func WorkContext(ctx context.Context) Canceller {
+	return DefaultClient.WorkContext(ctx)
+}
+
 // WorkForever will being processing registered queues. This routine will
 // block until SIGINT is received.
 func WorkForever() {
 	DefaultClient.WorkForever()
 }
+
+// WorkForeverContext will being processing registered queues. This routine
+// will block until SIGINT is received, or ctx is done.
+func WorkForeverContext(ctx context.Context) {
+	DefaultClient.WorkForeverContext(ctx)
+}
@@ -0,0 +1,134 @@
+package koda
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// periodicInterval is how often upcoming occurrences of periodic jobs are
+// submitted
+const periodicInterval = time.Second
+
+// periodicLockTTL is how long a client remains the leader of a periodic job
+// without renewing its lock
+const periodicLockTTL = 5 * time.Second
+
+// periodicLookahead is how far in advance occurrences are submitted, so they
+// are processed on time even while leadership passes to another client
+const periodicLookahead = periodicLockTTL + periodicInterval
+
+// periodicJob is a job submitted at every occurrence of its schedule.
+type periodicJob struct {
+	Queue    Queue
+	Schedule schedule
+	Payload  interface{}
+	Options  []SubmitOption
+
+	// Identifies the periodic job across clients, so that each occurrence is
+	// submitted once
+	key string
+}
+
+// RegisterPeriodic submits a job to queue at every occurrence of the schedule
+// spec, while the client is working. It may be called before or after Work.
+// spec is a cron expression with 5 fields (minute, hour, day of month, month,
+// day of week) or 6 fields (with a leading second), a descriptor such as
+// @daily or @hourly, or an interval such as "@every 1h30m". It may be
+// prefixed with "CRON_TZ=<zone> " to evaluate the expression in a time zone
+// other than the local one.
+//
+// Every client that registers the same queue, spec and payload competes to
+// lead the periodic job, and each occurrence is submitted by only one of
+// them. Occurrences are submitted to the delayed queue a few seconds ahead of
+// time.
+func (c *Client) RegisterPeriodic(queue Queue, spec string, payload interface{}, opts ...SubmitOption) error {
+	s, err := parseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	if s.Next(time.Now()).IsZero() {
+		return errors.New("schedule never occurs")
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	sum := sha1.Sum(append([]byte(spec+"\x00"), jsonPayload...))
+
+	c.periodicLock.Lock()
+	defer c.periodicLock.Unlock()
+
+	c.periodicJobs = append(c.periodicJobs, periodicJob{
+		Queue:    queue,
+		Schedule: s,
+		Payload:  payload,
+		Options:  opts,
+		key:      c.periodicKey(queue.Name, hex.EncodeToString(sum[:])),
+	})
+
+	return nil
+}
+
+// runPeriodic submits the upcoming occurrences of every periodic job until ctx
+// is done.
+func (c *Client) runPeriodic(ctx context.Context) {
+	ticker := time.NewTicker(periodicInterval)
+	defer ticker.Stop()
+
+	for {
+		c.periodicLock.Lock()
+		jobs := make([]periodicJob, len(c.periodicJobs))
+		copy(jobs, c.periodicJobs)
+		c.periodicLock.Unlock()
+
+		for _, p := range jobs {
+			c.submitPeriodic(p)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// submitPeriodic submits the occurrences of a periodic job that are due
+// within periodicLookahead, if this client leads the periodic job. Each
+// occurrence is claimed before it is submitted, so that it is only submitted
+// once, even if leadership has changed.
+func (c *Client) submitPeriodic(p periodicJob) error {
+	conn := c.getConn()
+	defer c.putConn(conn)
+
+	ok, err := conn.AcquireLock(p.key, c.workerID, periodicLockTTL)
+	if err != nil || !ok {
+		return err
+	}
+
+	now := time.Now()
+	until := now.Add(periodicLookahead)
+	for t := p.Schedule.Next(now); !t.IsZero() && !t.After(until); t = p.Schedule.Next(t) {
+		claimed, err := conn.SetNX(p.key+":"+formatTime(t), c.workerID, t.Sub(now)+periodicLookahead)
+		if err != nil {
+			return err
+		}
+
+		if !claimed {
+			continue
+		}
+
+		if _, err := c.SubmitAt(p.Queue, t, p.Payload, p.Options...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package koda
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSubmitPeriodic(t *testing.T) {
+	opts := optionsWithMock()
+	leader := NewClient(opts)
+	follower := NewClient(opts)
+	q := Queue{Name: "q"}
+
+	for _, client := range []*Client{leader, follower} {
+		if err := client.RegisterPeriodic(q, "@every 1s", "payload"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delayed := func() []string {
+		conn := leader.getConn()
+		defer leader.putConn(conn)
+
+		jobKeys, _ := conn.ZRangeByScore(leader.delayedQueueKey(q.Name), 0, math.Inf(1), true, true, 0, -1)
+		return jobKeys
+	}
+
+	if err := leader.submitPeriodic(leader.periodicJobs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	n := len(delayed())
+	if n < int(periodicLookahead/time.Second)-1 {
+		t.Fatalf("too few occurrences were submitted: %d", n)
+	}
+
+	if err := follower.submitPeriodic(follower.periodicJobs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(delayed()) != n {
+		t.Errorf("occurrences were submitted by a follower: %d != %d", len(delayed()), n)
+	}
+
+	// Occurrences already submitted are not submitted again by a new leader
+	follower.workerID = leader.workerID
+	if err := follower.submitPeriodic(follower.periodicJobs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(delayed()) > n+1 {
+		t.Errorf("occurrences were submitted more than once: %d > %d", len(delayed()), n)
+	}
+}
+
+func TestRegisterPeriodic_Invalid(t *testing.T) {
+	client := newTestClient()
+
+	if err := client.RegisterPeriodic(Queue{Name: "q"}, "0 0 30 2 *", nil); err == nil {
+		t.Error("expected an error for a schedule that never occurs")
+	}
+}
+
+func TestRegisterPeriodic_WhileWorking(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	canceller := client.Work()
+	defer canceller.Cancel()
+
+	if err := client.RegisterPeriodic(q, "@every 1s", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		conn := client.getConn()
+		jobKeys, _ := conn.ZRangeByScore(client.delayedQueueKey(q.Name), 0, math.Inf(1), true, true, 0, -1)
+		client.putConn(conn)
+
+		if len(jobKeys) > 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Error("periodic job registered while working was not submitted")
+}
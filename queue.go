@@ -21,5 +21,39 @@ type Queue struct {
 	// Default: 0
 	RetryInterval time.Duration
 
+	// Determines when failed jobs are retried
+	// Default: ConstantBackoff{Interval: RetryInterval}
+	RetryPolicy RetryPolicy
+
+	// The duration a claimed job is leased to a worker. Leases are renewed
+	// while the job's handler is running. If a lease expires, the attempt is
+	// considered failed and the job is retried by another worker.
+	// Default: 30s
+	VisibilityTimeout time.Duration
+
+	// The maximum duration of a single attempt. If exceeded, the attempt is
+	// considered failed, and the handler's context is cancelled. May be
+	// overridden for a single job using WithTimeout.
+	// Default: 0 (no timeout)
+	Timeout time.Duration
+
 	queueKeys []string
 }
+
+// withDefaults returns a copy of q with unset options set to their defaults
+func (q Queue) withDefaults() Queue {
+	if q.MaxAttempts < 1 {
+		q.MaxAttempts = 1
+	}
+	if q.NumWorkers < 1 {
+		q.NumWorkers = 1
+	}
+	if q.RetryPolicy == nil {
+		q.RetryPolicy = ConstantBackoff{Interval: q.RetryInterval}
+	}
+	if q.VisibilityTimeout <= 0 {
+		q.VisibilityTimeout = 30 * time.Second
+	}
+
+	return q
+}
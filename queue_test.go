@@ -1,6 +1,7 @@
 package koda
 
 import (
+	"math"
 	"reflect"
 	"testing"
 )
@@ -78,6 +79,10 @@ func TestWait_Delayed(t *testing.T) {
 
 	job, _ := client.SubmitDelayed(q, 0, nil)
 
+	conn := client.getConn()
+	client.promoteJobs(q, conn)
+	client.putConn(conn)
+
 	j, err := client.wait(q)
 	if err != nil {
 		t.Fatal(err)
@@ -103,3 +108,51 @@ func TestWait_Priority(t *testing.T) {
 		t.Fatal("failed to get highest priority job")
 	}
 }
+
+func TestWait_Processing(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+	client.Submit(q, 100, nil)
+
+	job, err := client.wait(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := client.getConn()
+	defer client.putConn(conn)
+
+	jobKeys, _ := conn.ZRangeByScore(client.processingKey(q.Name, client.workerID), 0, math.Inf(1), true, true, 0, -1)
+	if len(jobKeys) != 1 || jobKeys[0] != client.jobKey(job.ID) {
+		t.Errorf("job was not claimed: %v", jobKeys)
+	}
+}
+
+func TestWait_Corrupt(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+	job, _ := client.Submit(q, 100, nil)
+
+	conn := client.getConn()
+	defer client.putConn(conn)
+	conn.HSetAll(client.jobKey(job.ID), map[string]string{"state": "bogus"})
+
+	_, err := client.wait(q)
+	corruptErr, ok := err.(*CorruptJobError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if corruptErr.Key != client.jobKey(job.ID) || corruptErr.Field != "state" {
+		t.Errorf("unexpected error: %v", corruptErr)
+	}
+
+	jobKeys, _ := conn.ZRangeByScore(client.quarantineKey(), 0, math.Inf(1), true, true, 0, -1)
+	if len(jobKeys) != 1 || jobKeys[0] != client.jobKey(job.ID) {
+		t.Errorf("job was not quarantined: %v", jobKeys)
+	}
+
+	jobKeys, _ = conn.ZRangeByScore(client.processingKey(q.Name, client.workerID), 0, math.Inf(1), true, true, 0, -1)
+	if len(jobKeys) != 0 {
+		t.Errorf("job should no longer be claimed: %v", jobKeys)
+	}
+}
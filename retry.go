@@ -0,0 +1,124 @@
+package koda
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy determines when a failed job is retried. A job is never retried
+// once Job.NumAttempts has reached Queue.MaxAttempts.
+type RetryPolicy interface {
+	// NextRetry is given the failed job, its attempt number (starting at 1),
+	// and the error that caused the attempt to fail. It returns the delay
+	// until the next attempt, or false if the job should not be retried.
+	NextRetry(j *Job, attempt int, err error) (time.Duration, bool)
+}
+
+// ConstantBackoff retries jobs after a fixed interval.
+type ConstantBackoff struct {
+	Interval time.Duration
+}
+
+// NextRetry implements RetryPolicy.
+func (b ConstantBackoff) NextRetry(j *Job, attempt int, err error) (time.Duration, bool) {
+	return b.Interval, true
+}
+
+// LinearBackoff retries jobs after an interval that grows by Step after
+// each attempt.
+type LinearBackoff struct {
+	Initial time.Duration
+	Step    time.Duration
+
+	// The maximum delay between attempts
+	// Default: 0 (no maximum)
+	Max time.Duration
+}
+
+// NextRetry implements RetryPolicy.
+func (b LinearBackoff) NextRetry(j *Job, attempt int, err error) (time.Duration, bool) {
+	d := b.Initial + time.Duration(attempt-1)*b.Step
+	return capDelay(d, b.Max), true
+}
+
+// ExponentialBackoff retries jobs after an interval that is multiplied by
+// Multiplier after each attempt.
+type ExponentialBackoff struct {
+	Initial time.Duration
+
+	// Default: 2
+	Multiplier float64
+
+	// The maximum delay between attempts
+	// Default: 0 (no maximum)
+	Max time.Duration
+}
+
+// NextRetry implements RetryPolicy.
+func (b ExponentialBackoff) NextRetry(j *Job, attempt int, err error) (time.Duration, bool) {
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if d >= math.MaxInt64 {
+		return capDelay(math.MaxInt64, b.Max), true
+	}
+
+	return capDelay(time.Duration(d), b.Max), true
+}
+
+// DecorrelatedJitter retries jobs after a random interval between Base and
+// three times the previous interval, spreading out retries of jobs that
+// failed at the same time.
+type DecorrelatedJitter struct {
+	Base time.Duration
+
+	// The maximum delay between attempts
+	// Default: 0 (no maximum)
+	Max time.Duration
+}
+
+// NextRetry implements RetryPolicy.
+func (b DecorrelatedJitter) NextRetry(j *Job, attempt int, err error) (time.Duration, bool) {
+	prev := j.RetryDelay
+	if prev < b.Base {
+		prev = b.Base
+	}
+
+	d := b.Base
+	if hi := 3 * prev; hi > b.Base {
+		d += time.Duration(jitter.Int63n(int64(hi - b.Base)))
+	}
+
+	return capDelay(d, b.Max), true
+}
+
+// lockedRand is a source of random numbers that is safe for concurrent use.
+type lockedRand struct {
+	r    *rand.Rand
+	lock sync.Mutex
+}
+
+// Int63n returns a random number in [0, n).
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.r.Int63n(n)
+}
+
+// jitter is seeded for each process, as the global source of math/rand is
+// seeded with 1 before Go 1.20, which would give every worker the same delays.
+var jitter = &lockedRand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func capDelay(d time.Duration, max time.Duration) time.Duration {
+	if max > 0 && d > max {
+		return max
+	}
+
+	return d
+}
@@ -0,0 +1,114 @@
+package koda
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	cases := []struct {
+		Policy   RetryPolicy
+		Attempt  int
+		Expected time.Duration
+	}{
+		{ConstantBackoff{Interval: time.Second}, 3, time.Second},
+		{LinearBackoff{Initial: time.Second, Step: time.Second}, 3, 3 * time.Second},
+		{LinearBackoff{Initial: time.Second, Step: time.Second, Max: 2 * time.Second}, 3, 2 * time.Second},
+		{ExponentialBackoff{Initial: time.Second}, 4, 8 * time.Second},
+		{ExponentialBackoff{Initial: time.Second, Multiplier: 3}, 3, 9 * time.Second},
+		{ExponentialBackoff{Initial: time.Second, Max: 5 * time.Second}, 100, 5 * time.Second},
+	}
+
+	for _, c := range cases {
+		d, ok := c.Policy.NextRetry(&Job{}, c.Attempt, nil)
+		if !ok {
+			t.Errorf("%#v: job should be retried", c.Policy)
+		}
+		if d != c.Expected {
+			t.Errorf("%#v: unexpected delay: %s != %s", c.Policy, d, c.Expected)
+		}
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	policy := DecorrelatedJitter{Base: time.Second, Max: 10 * time.Second}
+	j := Job{RetryDelay: 2 * time.Second}
+
+	for i := 0; i < 100; i++ {
+		d, _ := policy.NextRetry(&j, 2, nil)
+		if d < time.Second || d >= 6*time.Second {
+			t.Fatalf("delay out of range: %s", d)
+		}
+	}
+}
+
+type giveUpPolicy struct{}
+
+func (giveUpPolicy) NextRetry(j *Job, attempt int, err error) (time.Duration, bool) {
+	return 0, false
+}
+
+func TestFail_RetryPolicy(t *testing.T) {
+	client := newTestClient()
+	q := Queue{
+		Name:        "q",
+		MaxAttempts: 5,
+		RetryPolicy: ConstantBackoff{Interval: time.Hour},
+	}
+
+	job, _ := client.Submit(q, 100, nil)
+	j, _ := client.wait(q)
+	if err := client.fail(&j, q, errors.New("")); err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ = client.Job(job.ID)
+	if j.State != Queued || j.RetryDelay != time.Hour {
+		t.Errorf("job was not retried: %s (delay: %s)", j.State, j.RetryDelay)
+	}
+	if j.DelayedUntil.Before(time.Now().Add(59 * time.Minute)) {
+		t.Error("unexpected delayed until:", j.DelayedUntil)
+	}
+
+	q.RetryPolicy = giveUpPolicy{}
+	job, _ = client.Submit(q, 100, nil)
+	j, _ = client.wait(q)
+	client.fail(&j, q, errors.New(""))
+
+	j, _ = client.Job(job.ID)
+	if j.State != Dead {
+		t.Error("job was not killed:", j.State)
+	}
+}
+
+func TestFail_Permanent(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 5}
+
+	job, _ := client.Submit(q, 100, nil)
+	j, _ := client.wait(q)
+	err := fmt.Errorf("validation failed: %w", Permanent(errors.New("bad input")))
+	client.fail(&j, q, err)
+
+	j, _ = client.Job(job.ID)
+	if j.State != Dead {
+		t.Error("job was not killed:", j.State)
+	}
+}
+
+func TestFail_RetryAfter(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q", MaxAttempts: 5}
+
+	job, _ := client.Submit(q, 100, nil)
+	j, _ := client.wait(q)
+	err := fmt.Errorf("rate limited: %w", RetryAfter(time.Minute, errors.New("429")))
+	client.fail(&j, q, err)
+
+	j, _ = client.Job(job.ID)
+	if j.State != Queued || j.RetryDelay != time.Minute {
+		t.Errorf("job was not retried: %s (delay: %s)", j.State, j.RetryDelay)
+	}
+}
@@ -0,0 +1,174 @@
+package koda
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxSchedulerSleep is the longest the scheduler sleeps before checking for
+// due jobs, in case it missed being woken
+const maxSchedulerSleep = time.Second
+
+// promoteBatchSize is the maximum number of jobs promoted by a single command
+const promoteBatchSize = 100
+
+// scheduler moves delayed jobs onto their priority queue once they are due,
+// so workers only need to wait on the priority queues. It sleeps until the
+// earliest job is due, and is woken by a message on a queue's scheduled
+// channel when a job is delayed, in case it is due sooner. Each client has a
+// single scheduler, which runs while the client is working any queue.
+type scheduler struct {
+	client *Client
+	queues []Queue
+	wake   chan struct{}
+	stop   context.CancelFunc
+	lock   sync.Mutex
+
+	// The unsubscribe functions of each queue's scheduled channel
+	unsubscribe map[string]func() error
+}
+
+// Add begins promoting the due jobs of queue, starting the scheduler if it is
+// not running.
+func (s *scheduler) Add(queue Queue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queues = append(s.queues, queue)
+
+	if s.stop == nil {
+		var ctx context.Context
+		ctx, s.stop = context.WithCancel(context.Background())
+		s.wake = make(chan struct{}, 1)
+		go s.run(ctx, s.wake)
+	}
+
+	if _, ok := s.unsubscribe[queue.Name]; !ok {
+		s.subscribe(queue)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Remove stops promoting the due jobs of queue, stopping the scheduler once
+// no queues remain.
+func (s *scheduler) Remove(queue Queue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.queues {
+		if s.queues[i].Name == queue.Name {
+			s.queues = append(s.queues[:i], s.queues[i+1:]...)
+			break
+		}
+	}
+
+	working := false
+	for i := range s.queues {
+		if s.queues[i].Name == queue.Name {
+			working = true
+		}
+	}
+
+	if unsubscribe, ok := s.unsubscribe[queue.Name]; ok && !working {
+		unsubscribe()
+		delete(s.unsubscribe, queue.Name)
+	}
+
+	if len(s.queues) == 0 && s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+}
+
+// subscribe wakes the scheduler whenever a message is published on the
+// queue's scheduled channel. If the subscription fails, the queue's jobs are
+// still promoted, but may be up to maxSchedulerSleep late.
+func (s *scheduler) subscribe(queue Queue) {
+	conn := s.client.getConn()
+	defer s.client.putConn(conn)
+
+	ch, unsubscribe, err := conn.Subscribe(s.client.scheduledChannel(queue.Name))
+	if err != nil {
+		return
+	}
+
+	if s.unsubscribe == nil {
+		s.unsubscribe = make(map[string]func() error)
+	}
+	s.unsubscribe[queue.Name] = unsubscribe
+
+	wake := s.wake
+	go func() {
+		for range ch {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
+
+func (s *scheduler) run(ctx context.Context, wake <-chan struct{}) {
+	for {
+		d := time.Until(s.promote())
+		if d > maxSchedulerSleep {
+			d = maxSchedulerSleep
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// promote promotes the due jobs of every queue, and returns when the next
+// job is due.
+func (s *scheduler) promote() time.Time {
+	s.lock.Lock()
+	queues := make([]Queue, len(s.queues))
+	copy(queues, s.queues)
+	s.lock.Unlock()
+
+	conn := s.client.getConn()
+	defer s.client.putConn(conn)
+
+	next := time.Now().Add(maxSchedulerSleep)
+	for _, q := range queues {
+		due, err := s.client.promoteJobs(q, conn)
+		if err == nil && !due.IsZero() && due.Before(next) {
+			next = due
+		}
+	}
+
+	return next
+}
+
+// promoteJobs moves the due jobs of a queue's delayed queue onto its priority
+// queues, and returns when the next delayed job is due. If the delayed queue
+// is empty, the returned time is zero.
+func (c *Client) promoteJobs(queue Queue, conn Conn) (time.Time, error) {
+	next, err := conn.PromoteJobs(
+		c.delayedQueueKey(queue.Name),
+		timeAsFloat(time.Now().UTC()),
+		c.priorityQueueKeyPrefix(queue.Name),
+		c.wakeKey(queue.Name),
+		promoteBatchSize)
+
+	if err != nil || math.IsInf(next, 1) {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, int64(next*float64(time.Second))).UTC(), nil
+}
@@ -0,0 +1,72 @@
+package koda
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	client.scheduler.Add(q)
+	defer client.scheduler.Remove(q)
+
+	// Wait for the scheduler to start sleeping
+	time.Sleep(10 * time.Millisecond)
+
+	delay := 50 * time.Millisecond
+	job, _ := client.SubmitDelayed(q, delay, nil)
+
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		j, err := client.wait(q)
+		if err != nil {
+			continue
+		}
+
+		if j.ID != job.ID {
+			t.Errorf("id mismatch: %d != %d", j.ID, job.ID)
+		}
+		if late := time.Since(job.DelayedUntil); late > 100*time.Millisecond {
+			t.Errorf("job was promoted %s late", late)
+		}
+		if early := time.Until(job.DelayedUntil); early > 0 {
+			t.Errorf("job was promoted %s early", early)
+		}
+		return
+	}
+
+	t.Fatal("job was not promoted")
+}
+
+func TestScheduledChannel(t *testing.T) {
+	client := newTestClient()
+	q := Queue{Name: "q"}
+
+	conn := client.getConn()
+	ch, unsubscribe, err := conn.Subscribe(client.scheduledChannel(q.Name))
+	client.putConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	client.Submit(q, 100, nil)
+	job, _ := client.SubmitDelayed(q, time.Minute, nil)
+
+	select {
+	case msg := <-ch:
+		if msg != strconv.Itoa(job.ID) {
+			t.Errorf("unexpected message: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delayed job was not published")
+	}
+
+	select {
+	case msg := <-ch:
+		t.Error("unexpected message:", msg)
+	default:
+	}
+}